@@ -18,6 +18,7 @@ package v1
 import (
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
 )
 
 // EDIT THIS FILE!  THIS IS SCAFFOLDING FOR YOU TO OWN!
@@ -28,30 +29,652 @@ type ChartSpec struct {
 	// INSERT ADDITIONAL SPEC FIELDS - desired state of cluster
 	// Important: Run "make" to regenerate code after modifying this file
 
-	// Specify the chart you would like to be applied to the cluster
-	Chart string `json:"chart"`
+	// Specify the chart you would like to be applied to the cluster, not
+	// used for git sources
+	// +optional
+	Chart string `json:"chart,omitempty"`
+
+	// Specify the repository for the chart, either the URL of a chart
+	// repository, an OCI registry path as oci://registry/path or
+	// stable/incubator, if empty, stable will be used
+	Repo string `json:"repo,omitempty"`
+
+	// Secret holding the credentials of the repository, either under the
+	// username and password keys or as a docker config for OCI registries
+	// +optional
+	SecretRef *corev1.SecretReference `json:"secretRef,omitempty"`
+
+	// Name of a ChartRepository to fetch the chart from, takes precedence
+	// over repo
+	// +optional
+	RepositoryRef *corev1.LocalObjectReference `json:"repositoryRef,omitempty"`
+
+	// What happens to namespaced resources templated with a namespace other
+	// than nameSpaceSelector, defaults to Allow
+	// +kubebuilder:validation:Enum=Allow;Override;Forbid
+	// +optional
+	NamespacePolicy NamespacePolicy `json:"namespacePolicy,omitempty"`
+
+	// Create nameSpaceSelector before applying the chart when it does not exist
+	// +optional
+	CreateNamespace bool `json:"createNamespace,omitempty"`
+
+	// Labels and annotations of the namespace created by createNamespace
+	// +optional
+	NamespaceMetadata *NamespaceMetadata `json:"namespaceMetadata,omitempty"`
+
+	// What happens to the namespace created by createNamespace when the chart
+	// is deleted, defaults to Retain
+	// +kubebuilder:validation:Enum=Retain;Delete
+	// +optional
+	NamespaceDeletionPolicy NamespaceDeletionPolicy `json:"namespaceDeletionPolicy,omitempty"`
+
+	// How CRDs shipped by the chart are applied, defaults to CreateOnly
+	// +kubebuilder:validation:Enum=CreateOnly;Update;Skip
+	// +optional
+	CRDPolicy CRDPolicy `json:"crdPolicy,omitempty"`
+
+	// Source the chart is read from instead of a chart repository
+	// +optional
+	Source *ChartSource `json:"source,omitempty"`
+
+	// Version of the chart or a semver range such as ~1.2 or >=2.0 <3,
+	// resolved against the repository on each sync. Not used for git sources
+	// +optional
+	Version           string `json:"version,omitempty"`
+	NameSpaceSelector string `json:"nameSpaceSelector"`
+
+	// ConfigMaps and Secrets holding values, merged in order over the
+	// defaults of the chart and under values
+	// +optional
+	ValuesFrom []ValuesReference `json:"valuesFrom,omitempty"`
+
+	// Values merged over the defaults of the chart, as a nested object like a
+	// values.yaml. A list of name/value pairs is still accepted and treated
+	// like setValues
+	// +optional
+	Values *runtime.RawExtension `json:"values,omitempty"`
+
+	// Deprecated: use values. Individual values applied over values with the
+	// same key syntax as `helm --set`
+	// +optional
+	SetValues []Value `json:"setValues,omitempty"`
+
+	// How long to wait for each hook to complete and for the resources of the
+	// chart to become healthy before the chart fails, defaults to 5m
+	// +optional
+	Timeout *metav1.Duration `json:"timeout,omitempty"`
+
+	// Deploy a previous release revision instead of the chart, and roll back
+	// failed upgrades
+	// +optional
+	Rollback *Rollback `json:"rollback,omitempty"`
+
+	// Number of release revisions kept in the history, defaults to 10
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	MaxHistory int32 `json:"maxHistory,omitempty"`
+
+	// How failed installs are retried and remediated
+	// +optional
+	Install *InstallSpec `json:"install,omitempty"`
+
+	// How failed upgrades are retried and remediated
+	// +optional
+	Upgrade *UpgradeSpec `json:"upgrade,omitempty"`
+
+	// Whether changes made to the resources of the chart outside of the
+	// operator are corrected (enabled), only reported (warn) or not looked
+	// for (disabled), defaults to disabled
+	// +kubebuilder:validation:Enum=enabled;warn;disabled
+	// +optional
+	DriftDetection DriftDetectionMode `json:"driftDetection,omitempty"`
+
+	// Fields of the resources of the chart that are left to other
+	// controllers, such as replica counts managed by autoscalers
+	// +optional
+	DriftIgnore []DriftIgnoreRule `json:"driftIgnore,omitempty"`
+
+	// Render the chart and preview the changes applying it would make in
+	// status.diff, without changing the cluster
+	// +optional
+	SuspendApply bool `json:"suspendApply,omitempty"`
+
+	// Service account in nameSpaceSelector the resources of the chart are
+	// managed as, the operator's own account is used when not set
+	// +optional
+	ServiceAccountName string `json:"serviceAccountName,omitempty"`
+
+	// Whether newer versions matching a version range are rolled out as
+	// they are published (auto) or once approved (manual-approval), defaults
+	// to auto
+	// +kubebuilder:validation:Enum=auto;manual-approval
+	// +optional
+	UpgradePolicy UpgradePolicy `json:"upgradePolicy,omitempty"`
+
+	// Version matching the version range that may be rolled out with the
+	// manual-approval upgrade policy, such as status.availableVersion
+	// +optional
+	ApprovedVersion string `json:"approvedVersion,omitempty"`
+}
+
+// InstallSpec configures the first release of a chart
+type InstallSpec struct {
+	// +optional
+	Remediation *Remediation `json:"remediation,omitempty"`
+}
+
+// UpgradeSpec configures releases of a chart after the first
+type UpgradeSpec struct {
+	// +optional
+	Remediation *Remediation `json:"remediation,omitempty"`
+}
+
+// Remediation decides how often a failed release is retried and what happens
+// once the retries are used up. Releases without a remediation are retried
+// forever
+type Remediation struct {
+	// Number of times a failed release is retried, -1 retries forever
+	// +kubebuilder:validation:Minimum=-1
+	// +optional
+	Retries int32 `json:"retries,omitempty"`
+
+	// Delay before the first retry, doubled after each failure, defaults to 30s
+	// +optional
+	Backoff *metav1.Duration `json:"backoff,omitempty"`
+
+	// Longest delay between retries, defaults to 10m
+	// +optional
+	MaxBackoff *metav1.Duration `json:"maxBackoff,omitempty"`
+
+	// What happens once the retries are used up, defaults to None
+	// +kubebuilder:validation:Enum=None;Rollback;Uninstall
+	// +optional
+	Strategy RemediationStrategy `json:"strategy,omitempty"`
+}
+
+// DriftDetectionMode decides what happens when resources of a chart are
+// changed or deleted outside of the operator
+type DriftDetectionMode string
+
+const (
+	// Apply the chart again to revert the changes
+	DriftDetectionEnabled DriftDetectionMode = "enabled"
+	// Report the changes in the status of the chart and leave them
+	DriftDetectionWarn DriftDetectionMode = "warn"
+	// Do not look for changes
+	DriftDetectionDisabled DriftDetectionMode = "disabled"
+)
+
+// UpgradePolicy decides whether newer versions matching a version range are
+// rolled out
+type UpgradePolicy string
+
+const (
+	// Roll out the newest matching version as soon as it is published
+	UpgradePolicyAuto UpgradePolicy = "auto"
+	// Keep the deployed version until a newer one is approved
+	UpgradePolicyManualApproval UpgradePolicy = "manual-approval"
+)
+
+// DriftIgnoreRule leaves fields of resources out of drift detection, ignored
+// fields keep their live value when the chart is applied
+type DriftIgnoreRule struct {
+	// JSON pointers to the ignored fields, such as /spec/replicas
+	Paths []string `json:"paths"`
+
+	// Resources the rule applies to, every resource of the chart when not set
+	// +optional
+	Target *DriftTarget `json:"target,omitempty"`
+}
+
+// DriftTarget selects resources of a chart, empty fields match any resource
+type DriftTarget struct {
+	// +optional
+	Kind string `json:"kind,omitempty"`
+	// +optional
+	Name string `json:"name,omitempty"`
+	// +optional
+	Namespace string `json:"namespace,omitempty"`
+}
+
+// RemediationStrategy is applied to a release once its retries are used up,
+// after which the chart is stalled until the chart or its values change
+type RemediationStrategy string
+
+const (
+	// Leave the failed release as it is
+	RemediationNone RemediationStrategy = "None"
+	// Deploy the previous revision again, installs have none and are left as
+	// they are
+	RemediationRollback RemediationStrategy = "Rollback"
+	// Delete the resources of the chart
+	RemediationUninstall RemediationStrategy = "Uninstall"
+)
+
+// Rollback pins a chart to a previous release revision or rolls it back
+// automatically
+type Rollback struct {
+	// Release revision from status.history to deploy instead of rendering the
+	// chart, clear it to deploy the chart again
+	// +optional
+	Revision int64 `json:"revision,omitempty"`
+
+	// Roll back to the deployed revision when an upgrade fails its health
+	// checks or post-upgrade hooks. The failed chart and values are not
+	// deployed again until they change
+	// +optional
+	OnFailure bool `json:"onFailure,omitempty"`
+}
 
-	// Specify the repository for the chart, if empty, stable will be used
-	Repo              string  `json:"repo"`
-	Version           string  `json:"version"`
-	NameSpaceSelector string  `json:"nameSpaceSelector"`
-	Values            []Value `json:"values,omitempty"`
+// NamespacePolicy decides what happens to resources templated with their own
+// namespace, resources without one are always deployed to nameSpaceSelector
+type NamespacePolicy string
+
+const (
+	// Keep the namespace set by the template
+	NamespacePolicyAllow NamespacePolicy = "Allow"
+	// Deploy every namespaced resource to nameSpaceSelector
+	NamespacePolicyOverride NamespacePolicy = "Override"
+	// Fail the chart when a template targets another namespace
+	NamespacePolicyForbid NamespacePolicy = "Forbid"
+)
+
+// NamespaceMetadata is set on namespaces created for a chart
+type NamespaceMetadata struct {
+	// +optional
+	Labels map[string]string `json:"labels,omitempty"`
+	// +optional
+	Annotations map[string]string `json:"annotations,omitempty"`
 }
 
+// NamespaceDeletionPolicy decides whether a namespace created for a chart
+// outlives it
+type NamespaceDeletionPolicy string
+
+const (
+	// Keep the namespace when the chart is deleted
+	NamespaceDeletionPolicyRetain NamespaceDeletionPolicy = "Retain"
+	// Delete the namespace, and everything in it, with the chart
+	NamespaceDeletionPolicyDelete NamespaceDeletionPolicy = "Delete"
+)
+
+// CRDPolicy decides how CRDs shipped by a chart are applied, CRDs are never
+// deleted with the chart as that would delete every resource of their kinds
+type CRDPolicy string
+
+const (
+	// Create missing CRDs and leave existing ones alone
+	CRDPolicyCreateOnly CRDPolicy = "CreateOnly"
+	// Create missing CRDs and update existing ones
+	CRDPolicyUpdate CRDPolicy = "Update"
+	// Never touch CRDs, they are expected to be installed separately
+	CRDPolicySkip CRDPolicy = "Skip"
+)
+
+// ChartSource points at an unpackaged chart
+type ChartSource struct {
+	// Git repository holding the chart
+	// +optional
+	Git *GitSource `json:"git,omitempty"`
+}
+
+// GitSource is a chart directory in a git repository
+type GitSource struct {
+	// URL of the repository, anything git clone accepts
+	URL string `json:"url"`
+
+	// Branch, tag or commit to check out, defaults to the default branch
+	// +optional
+	Ref string `json:"ref,omitempty"`
+
+	// Path of the chart directory inside the repository, defaults to the root
+	// +optional
+	Path string `json:"path,omitempty"`
+}
+
+// ValuesReference reads values from a key of a ConfigMap or Secret
+type ValuesReference struct {
+	// Kind of the object, ConfigMap or Secret
+	Kind string `json:"kind"`
+
+	// Name of the object
+	Name string `json:"name"`
+
+	// Namespace of the object, defaults to nameSpaceSelector
+	// +optional
+	Namespace string `json:"namespace,omitempty"`
+
+	// Key holding the values, defaults to values.yaml
+	// +optional
+	Key string `json:"key,omitempty"`
+
+	// Path the content of the key is set at as a single string value, by
+	// default the content is parsed as a values file
+	// +optional
+	TargetPath string `json:"targetPath,omitempty"`
+
+	// Ignore the reference when the object or key does not exist
+	// +optional
+	Optional bool `json:"optional,omitempty"`
+}
+
+// Value is a single value set with the key syntax of `helm --set`, such as
+// servers[0].port, dots in names are escaped with a backslash
 type Value struct {
 	Name  string `json:"name"`
 	Value string `json:"value"`
+
+	// Keep the value a string like `helm --set-string` instead of converting
+	// booleans, integers and null
+	// +optional
+	ForceString bool `json:"forceString,omitempty"`
 }
 
 // ChartStatus defines the observed state of Chart
 type ChartStatus struct {
 	// INSERT ADDITIONAL STATUS FIELD - define observed state of cluster
 	// Important: Run "make" to regenerate code after modifying this file
+
+	// Deprecated: use conditions. Deployed or Failed
 	Status string `json:"status,omitempty"`
 
+	// Generation of the spec the status was computed for
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// Revision of the chart last deployed successfully, its version or the
+	// commit of a git source
+	// +optional
+	LastAppliedRevision string `json:"lastAppliedRevision,omitempty"`
+
+	// Revision of the chart of the last reconcile, whether it succeeded or not
+	// +optional
+	LastAttemptedRevision string `json:"lastAttemptedRevision,omitempty"`
+
+	// Version the version range of the chart resolved to on the last sync
+	// +optional
+	ResolvedVersion string `json:"resolvedVersion,omitempty"`
+
+	// Newest version matching the version range, waiting to be approved
+	// with the manual-approval upgrade policy
+	// +optional
+	AvailableVersion string `json:"availableVersion,omitempty"`
+
+	// Namespace created by the operator for the chart
+	// +optional
+	CreatedNamespace string `json:"createdNamespace,omitempty"`
+
+	// Commit of the git source the deployed chart was rendered from
+	// +optional
+	GitCommit string `json:"gitCommit,omitempty"`
+
+	// Digest of the chart and values last deployed, install and upgrade hooks
+	// only run when it changes
+	// +optional
+	DeployedDigest string `json:"deployedDigest,omitempty"`
+
+	// Release revision currently deployed
+	// +optional
+	CurrentRevision int64 `json:"currentRevision,omitempty"`
+
+	// Consecutive failed attempts to deploy the latest revision
+	// +optional
+	Failures int64 `json:"failures,omitempty"`
+
+	// Digest of the chart and values of an upgrade that was rolled back, they
+	// are not deployed again until they change
+	// +optional
+	RolledBackDigest string `json:"rolledBackDigest,omitempty"`
+
+	// Release revisions of the chart, oldest first
+	// +optional
+	History []ReleaseRecord `json:"history,omitempty"`
+
 	// A list of resource created by chart.
 	// +optional
 	Resource []corev1.ObjectReference `json:"resource,omitempty"`
+
+	// Results of the last run of each hook of the chart
+	// +optional
+	Hooks []HookStatus `json:"hooks,omitempty"`
+
+	// Health of the resources of the chart that have a health check
+	// +optional
+	ResourceHealth []ResourceHealth `json:"resourceHealth,omitempty"`
+
+	// Time the resources of the release being deployed have to be healthy
+	// by, the release fails with the Timeout reason once it passed
+	// +optional
+	HealthDeadline *metav1.Time `json:"healthDeadline,omitempty"`
+
+	// Resources that drifted from the chart and were left as they are
+	// +optional
+	Drift []DriftedResource `json:"drift,omitempty"`
+
+	// Changes applying the chart would make, previewed while suspendApply is
+	// set
+	// +optional
+	Diff *ChartDiff `json:"diff,omitempty"`
+
+	// Conditions of the chart, one for each step of the reconcile along with
+	// Ready and Stalled
+	// +optional
+	Conditions []Condition `json:"conditions,omitempty"`
+}
+
+// Condition types of a chart
+const (
+	// The chart was fetched from its repository or source
+	ChartFetched = "Fetched"
+	// The chart was templated with its values
+	ChartRendered = "Rendered"
+	// The resources and hooks of the chart were applied
+	ChartApplied = "Applied"
+	// The chart is deployed and every resource is healthy
+	ChartReady = "Ready"
+	// The chart failed in a way retrying cannot fix, it is reconciled again
+	// once its spec or values change
+	ChartStalled = "Stalled"
+	// Resources of the chart were changed outside of the operator and the
+	// changes were left in place
+	ChartDrifted = "Drifted"
+)
+
+// HealthStatus is the assessed health of a deployed resource
+type HealthStatus string
+
+const (
+	// The resource is doing what it was deployed to do
+	HealthHealthy HealthStatus = "Healthy"
+	// The resource is still rolling out
+	HealthProgressing HealthStatus = "Progressing"
+	// The resource failed and will not recover without a change
+	HealthUnhealthy HealthStatus = "Unhealthy"
+)
+
+// ChartDiff previews the changes applying a chart would make
+type ChartDiff struct {
+	// Revision of the chart the diff was computed for
+	// +optional
+	Revision string `json:"revision,omitempty"`
+
+	// Digest of the chart and values the diff was computed for
+	// +optional
+	Digest string `json:"digest,omitempty"`
+
+	// When the diff was computed
+	Time metav1.Time `json:"time"`
+
+	// Resources that would be created, updated or deleted, unchanged resources
+	// are left out
+	// +optional
+	Changes []ResourceChange `json:"changes,omitempty"`
+}
+
+// ChangeAction is what applying a chart would do to a resource
+type ChangeAction string
+
+const (
+	// The resource does not exist yet
+	ChangeCreate ChangeAction = "Create"
+	// Fields set by the chart differ from the live resource
+	ChangeUpdate ChangeAction = "Update"
+	// The resource is no longer rendered by the chart
+	ChangeDelete ChangeAction = "Delete"
+)
+
+// ResourceChange is the change applying a chart would make to a resource
+type ResourceChange struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Name       string `json:"name"`
+	// +optional
+	Namespace string `json:"namespace,omitempty"`
+
+	Action ChangeAction `json:"action"`
+
+	// Fields an update changes, as JSON pointers
+	// +optional
+	Fields []string `json:"fields,omitempty"`
+
+	// Patch an update applies
+	// +optional
+	Patch string `json:"patch,omitempty"`
+}
+
+// DriftReason says how a resource drifted from the chart
+type DriftReason string
+
+const (
+	// Fields set by the chart were changed
+	DriftModified DriftReason = "Modified"
+	// The resource was deleted
+	DriftDeleted DriftReason = "Deleted"
+)
+
+// DriftedResource is a resource of a chart that no longer matches it
+type DriftedResource struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Name       string `json:"name"`
+	// +optional
+	Namespace string `json:"namespace,omitempty"`
+
+	Reason DriftReason `json:"reason"`
+
+	// Patch that reverts the changes to a modified resource
+	// +optional
+	Patch string `json:"patch,omitempty"`
+}
+
+// ResourceHealth is the health of a single resource of a chart
+type ResourceHealth struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Name       string `json:"name"`
+	// +optional
+	Namespace string `json:"namespace,omitempty"`
+
+	Health HealthStatus `json:"health"`
+
+	// Why the resource is not healthy
+	// +optional
+	Message string `json:"message,omitempty"`
+}
+
+// ReleaseOutcome is the result of deploying a release revision
+type ReleaseOutcome string
+
+const (
+	// The revision is being deployed
+	ReleasePending ReleaseOutcome = "Pending"
+	// The revision is deployed
+	ReleaseDeployed ReleaseOutcome = "Deployed"
+	// The revision was deployed and replaced by a later one
+	ReleaseSuperseded ReleaseOutcome = "Superseded"
+	// Deploying the revision failed
+	ReleaseFailed ReleaseOutcome = "Failed"
+)
+
+// ReleaseRecord is a revision of a chart in its release history, the
+// rendered manifest of each revision is kept in a Secret in nameSpaceSelector
+type ReleaseRecord struct {
+	Revision int64 `json:"revision"`
+
+	// Revision of the chart, its version or the commit of a git source
+	// +optional
+	ChartRevision string `json:"chartRevision,omitempty"`
+
+	// Name of the chart in its Chart.yaml
+	// +optional
+	Chart string `json:"chart,omitempty"`
+
+	// Version of the chart in its Chart.yaml
+	// +optional
+	ChartVersion string `json:"chartVersion,omitempty"`
+
+	// Digest of the chart and values
+	Digest string `json:"digest"`
+
+	// Digest of the values
+	// +optional
+	ValuesDigest string `json:"valuesDigest,omitempty"`
+
+	// Digest of the rendered manifest
+	// +optional
+	ManifestDigest string `json:"manifestDigest,omitempty"`
+
+	// When the revision was first deployed
+	Time metav1.Time `json:"time"`
+
+	Outcome ReleaseOutcome `json:"outcome"`
+
+	// What the revision was, such as Install or Rollback to 2, and why it
+	// failed
+	// +optional
+	Description string `json:"description,omitempty"`
+}
+
+// HookPhase is the state of a hook run
+type HookPhase string
+
+const (
+	// The hook was created and has not completed yet
+	HookPhaseRunning HookPhase = "Running"
+	// The hook completed successfully
+	HookPhaseSucceeded HookPhase = "Succeeded"
+	// The hook failed or did not complete in time
+	HookPhaseFailed HookPhase = "Failed"
+)
+
+// HookStatus is the result of running a hook for a lifecycle event
+type HookStatus struct {
+	Name string `json:"name"`
+	Kind string `json:"kind"`
+
+	// Lifecycle event the hook ran for, such as pre-install
+	Event string `json:"event"`
+
+	Phase HookPhase `json:"phase"`
+
+	// Why the hook failed
+	// +optional
+	Message string `json:"message,omitempty"`
+
+	// Digest of the chart and values the hook ran for, a hook that succeeded
+	// is not run again for the same digest
+	// +optional
+	Digest string `json:"digest,omitempty"`
+
+	// When the hook was created, a hook still running once the timeout of
+	// the chart passed fails
+	// +optional
+	StartTime *metav1.Time `json:"startTime,omitempty"`
+
+	// When the hook completed
+	// +optional
+	CompletionTime *metav1.Time `json:"completionTime,omitempty"`
 }
 
 // +kubebuilder:object:root=true
@@ -57,6 +57,11 @@ var _ = Describe("Chart", func() {
 				ObjectMeta: metav1.ObjectMeta{
 					Name:      "foo",
 					Namespace: "",
+				},
+				Spec: ChartSpec{
+					Chart:             "nginx-ingress",
+					Version:           "1.1.0",
+					NameSpaceSelector: "default",
 				}}
 
 			By("creating an API obj")
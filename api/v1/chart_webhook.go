@@ -0,0 +1,192 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/url"
+	"strings"
+
+	"github.com/Masterminds/semver"
+	"github.com/Spazzy757/helm-operator/render"
+	"github.com/Spazzy757/helm-operator/repository"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// Paths the webhooks of Chart are served at
+const (
+	chartDefaultingPath = "/mutate-stable-helm-operator-io-v1-chart"
+	chartValidatingPath = "/validate-stable-helm-operator-io-v1-chart"
+)
+
+// SetupWebhookWithManager registers the defaulting and validating webhooks
+// of Chart with the webhook server of the manager
+func (r *Chart) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	server := mgr.GetWebhookServer()
+	server.Register(chartDefaultingPath, admission.DefaultingWebhookFor(r))
+	server.Register(chartValidatingPath, admission.ValidatingWebhookFor(r))
+	return nil
+}
+
+// +kubebuilder:webhook:path=/mutate-stable-helm-operator-io-v1-chart,mutating=true,failurePolicy=fail,groups=stable.helm.operator.io,resources=charts,verbs=create;update,versions=v1,name=mchart.kb.io
+
+var _ admission.Defaulter = &Chart{}
+
+// Default fills repo with stable for charts fetched from a chart repository
+// and normalises nameSpaceSelector
+func (r *Chart) Default() {
+	defaultChartSpec(&r.Spec)
+}
+
+// +kubebuilder:webhook:path=/validate-stable-helm-operator-io-v1-chart,mutating=false,failurePolicy=fail,groups=stable.helm.operator.io,resources=charts,verbs=create;update,versions=v1,name=vchart.kb.io
+
+var _ admission.Validator = &Chart{}
+
+// ValidateCreate rejects charts that could never be fetched or rendered
+func (r *Chart) ValidateCreate() error {
+	return r.invalid(validateChartSpec(&r.Spec, field.NewPath("spec")))
+}
+
+// ValidateUpdate rejects changes of nameSpaceSelector along with the specs
+// ValidateCreate rejects. Updates leaving the spec as it was, such as those
+// of finalizers, are always allowed so charts created before the webhook can
+// still be deleted
+func (r *Chart) ValidateUpdate(old runtime.Object) error {
+	oldChart, ok := old.(*Chart)
+	if !ok || equality.Semantic.DeepEqual(oldChart.Spec, r.Spec) {
+		return nil
+	}
+	return r.invalid(validateChartUpdate(&r.Spec, &oldChart.Spec, field.NewPath("spec")))
+}
+
+// Returns the errors as an Invalid error of the chart
+func (r *Chart) invalid(errs field.ErrorList) error {
+	if len(errs) == 0 {
+		return nil
+	}
+	return apierrors.NewInvalid(GroupVersion.WithKind("Chart").GroupKind(), r.Name, errs)
+}
+
+// Returns whether the chart is read from a git repository
+func isGitSource(spec *ChartSpec) bool {
+	return spec.Source != nil && spec.Source.Git != nil
+}
+
+// Fills repo with stable for charts fetched from a chart repository and
+// normalises nameSpaceSelector
+func defaultChartSpec(spec *ChartSpec) {
+	if spec.Repo == "" && spec.RepositoryRef == nil && !isGitSource(spec) {
+		spec.Repo = "stable"
+	}
+	spec.NameSpaceSelector = strings.ToLower(strings.TrimSpace(spec.NameSpaceSelector))
+}
+
+// Validates an update of a chart spec, nameSpaceSelector cannot be changed
+func validateChartUpdate(spec, oldSpec *ChartSpec, specPath *field.Path) field.ErrorList {
+	var errs field.ErrorList
+	if spec.NameSpaceSelector != oldSpec.NameSpaceSelector {
+		errs = append(errs, field.Forbidden(specPath.Child("nameSpaceSelector"),
+			"cannot be changed, the resources of the chart would be left in the previous namespace"))
+	}
+	return append(errs, validateChartSpec(spec, specPath)...)
+}
+
+// Validates the fields of a chart spec the schema cannot. Only the syntax of
+// repositoryRef is checked, a ChartRepository that does not exist is reported
+// by the controller
+func validateChartSpec(spec *ChartSpec, specPath *field.Path) field.ErrorList {
+	var errs field.ErrorList
+	if !isGitSource(spec) {
+		if spec.Chart == "" {
+			errs = append(errs, field.Required(specPath.Child("chart"), "charts not read from git need a chart"))
+		}
+		if spec.Version == "" {
+			errs = append(errs, field.Required(specPath.Child("version"), "charts not read from git need a version"))
+		} else if _, err := repository.ParseRange(spec.Version); err != nil {
+			errs = append(errs, field.Invalid(specPath.Child("version"), spec.Version, "must be a semver version or range"))
+		}
+		if v := spec.ApprovedVersion; v != "" {
+			if _, err := semver.NewVersion(v); err != nil {
+				errs = append(errs, field.Invalid(specPath.Child("approvedVersion"), v, "must be a semver version"))
+			}
+		}
+		if ref := spec.RepositoryRef; ref == nil {
+			errs = append(errs, validateRepo(spec.Repo, specPath.Child("repo"))...)
+		} else {
+			for _, msg := range validation.IsDNS1123Subdomain(ref.Name) {
+				errs = append(errs, field.Invalid(specPath.Child("repositoryRef", "name"), ref.Name, msg))
+			}
+		}
+	}
+	if ns := spec.NameSpaceSelector; ns != "" {
+		for _, msg := range validation.IsDNS1123Label(ns) {
+			errs = append(errs, field.Invalid(specPath.Child("nameSpaceSelector"), ns, msg))
+		}
+	}
+	for i, v := range spec.SetValues {
+		if err := render.ValidatePath(v.Name); err != nil {
+			errs = append(errs, field.Invalid(specPath.Child("setValues").Index(i).Child("name"), v.Name, err.Error()))
+		}
+	}
+	for i, ref := range spec.ValuesFrom {
+		if ref.TargetPath == "" {
+			continue
+		}
+		if err := render.ValidatePath(ref.TargetPath); err != nil {
+			errs = append(errs, field.Invalid(specPath.Child("valuesFrom").Index(i).Child("targetPath"), ref.TargetPath, err.Error()))
+		}
+	}
+	// values holding name/value pairs are treated like setValues
+	if spec.Values != nil && bytes.HasPrefix(bytes.TrimSpace(spec.Values.Raw), []byte("[")) {
+		var legacy []Value
+		if err := json.Unmarshal(spec.Values.Raw, &legacy); err != nil {
+			errs = append(errs, field.Invalid(specPath.Child("values"), string(spec.Values.Raw), err.Error()))
+		}
+		for i, v := range legacy {
+			if err := render.ValidatePath(v.Name); err != nil {
+				errs = append(errs, field.Invalid(specPath.Child("values").Index(i).Child("name"), v.Name, err.Error()))
+			}
+		}
+	}
+	return errs
+}
+
+// Validates repo is stable, incubator, an OCI registry path or the URL of a
+// chart repository, an empty repo means stable
+func validateRepo(repo string, repoPath *field.Path) field.ErrorList {
+	if repo == "" || repository.IsWellKnown(repo) {
+		return nil
+	}
+	if repository.IsOCI(repo) {
+		if strings.TrimPrefix(repo, repository.OCIScheme) == "" {
+			return field.ErrorList{field.Invalid(repoPath, repo, "must be an OCI registry path as oci://registry/path")}
+		}
+		return nil
+	}
+	u, err := url.Parse(repo)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return field.ErrorList{field.NotSupported(repoPath, repo,
+			[]string{"stable", "incubator", "http(s)://<chart repository>", "oci://<registry>/<path>"})}
+	}
+	return nil
+}
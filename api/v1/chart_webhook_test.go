@@ -0,0 +1,141 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"golang.org/x/net/context"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+var _ = Describe("Chart webhooks", func() {
+	var chart *Chart
+
+	BeforeEach(func() {
+		chart = &Chart{
+			ObjectMeta: metav1.ObjectMeta{Name: "webhook"},
+			Spec: ChartSpec{
+				Chart:             "nginx-ingress",
+				Version:           "1.1.0",
+				NameSpaceSelector: "default",
+			},
+		}
+	})
+
+	AfterEach(func() {
+		k8sClient.Delete(context.TODO(), chart)
+	})
+
+	// Creates the chart and returns the causes it was rejected for
+	rejected := func() []string {
+		err := k8sClient.Create(context.TODO(), chart)
+		Expect(apierrors.IsInvalid(err)).To(BeTrue(), "expected an invalid error, got %v", err)
+		var fields []string
+		for _, cause := range err.(apierrors.APIStatus).Status().Details.Causes {
+			fields = append(fields, cause.Field)
+		}
+		return fields
+	}
+
+	It("should default repo and normalise nameSpaceSelector", func() {
+		chart.Spec.NameSpaceSelector = " Default "
+		Expect(k8sClient.Create(context.TODO(), chart)).To(Succeed())
+
+		fetched := &Chart{}
+		Expect(k8sClient.Get(context.TODO(), types.NamespacedName{Name: "webhook"}, fetched)).To(Succeed())
+		Expect(fetched.Spec.Repo).To(Equal("stable"))
+		Expect(fetched.Spec.NameSpaceSelector).To(Equal("default"))
+	})
+
+	It("should leave repo empty for git sources", func() {
+		chart.Spec = ChartSpec{
+			Source:            &ChartSource{Git: &GitSource{URL: "https://github.com/example/charts.git"}},
+			NameSpaceSelector: "default",
+		}
+		Expect(k8sClient.Create(context.TODO(), chart)).To(Succeed())
+		Expect(chart.Spec.Repo).To(BeEmpty())
+	})
+
+	It("should reject charts without a chart or version", func() {
+		chart.Spec.Chart = ""
+		chart.Spec.Version = ""
+		Expect(rejected()).To(ConsistOf("spec.chart", "spec.version"))
+	})
+
+	It("should reject versions that are not semver", func() {
+		chart.Spec.Version = "latest"
+		chart.Spec.ApprovedVersion = "next"
+		Expect(rejected()).To(ConsistOf("spec.version", "spec.approvedVersion"))
+	})
+
+	It("should accept version ranges", func() {
+		chart.Spec.Version = ">=1.1 <2"
+		chart.Spec.UpgradePolicy = UpgradePolicyManualApproval
+		Expect(k8sClient.Create(context.TODO(), chart)).To(Succeed())
+	})
+
+	It("should reject malformed value paths", func() {
+		chart.Spec.SetValues = []Value{{Name: "servers[0.port", Value: "80"}, {Name: "[0]", Value: "80"}}
+		Expect(rejected()).To(ConsistOf("spec.setValues[0].name", "spec.setValues[1].name"))
+
+		chart.Spec.SetValues = nil
+		chart.Spec.Values = &runtime.RawExtension{Raw: []byte(`[{"name":"a[x]","value":"1"}]`)}
+		Expect(rejected()).To(ConsistOf("spec.values[0].name"))
+
+		chart.Spec.Values = nil
+		chart.Spec.ValuesFrom = []ValuesReference{{Kind: "ConfigMap", Name: "values", TargetPath: "a[999999999]"}}
+		Expect(rejected()).To(ConsistOf("spec.valuesFrom[0].targetPath"))
+	})
+
+	It("should reject unknown repos", func() {
+		chart.Spec.Repo = "bitnami"
+		Expect(rejected()).To(ConsistOf("spec.repo"))
+
+		for _, repo := range []string{"incubator", "https://charts.example.com", "oci://registry.example.com/charts"} {
+			chart.Spec.Repo = repo
+			Expect(k8sClient.Create(context.TODO(), chart)).To(Succeed())
+			Expect(k8sClient.Delete(context.TODO(), chart)).To(Succeed())
+			chart.ResourceVersion = ""
+		}
+	})
+
+	It("should only check the syntax of repositoryRef", func() {
+		chart.Spec.RepositoryRef = &corev1.LocalObjectReference{Name: "Not_A_Name"}
+		Expect(rejected()).To(ConsistOf("spec.repositoryRef.name"))
+
+		chart.Spec.RepositoryRef.Name = "missing"
+		Expect(k8sClient.Create(context.TODO(), chart)).To(Succeed())
+	})
+
+	It("should reject changes of nameSpaceSelector", func() {
+		Expect(k8sClient.Create(context.TODO(), chart)).To(Succeed())
+
+		chart.Spec.NameSpaceSelector = "other"
+		err := k8sClient.Update(context.TODO(), chart)
+		Expect(apierrors.IsInvalid(err)).To(BeTrue())
+
+		fetched := &Chart{}
+		Expect(k8sClient.Get(context.TODO(), types.NamespacedName{Name: "webhook"}, fetched)).To(Succeed())
+		fetched.Spec.Version = "1.2.0"
+		Expect(k8sClient.Update(context.TODO(), fetched)).To(Succeed())
+	})
+})
@@ -0,0 +1,97 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ChartPolicySpec defines which charts may be installed and what they may
+// deploy, empty lists do not restrict anything
+type ChartPolicySpec struct {
+	// Labels of the charts the policy applies to, every chart when not set
+	// +optional
+	ChartSelector *metav1.LabelSelector `json:"chartSelector,omitempty"`
+
+	// URLs of the chart repositories, OCI registries and git repositories
+	// charts may be fetched from, as glob patterns. stable and incubator are
+	// matched by their URL
+	// +optional
+	Repositories []string `json:"repositories,omitempty"`
+
+	// Charts that may be installed
+	// +optional
+	Charts []ChartRule `json:"charts,omitempty"`
+
+	// Namespaces charts may deploy into, as glob patterns
+	// +optional
+	Namespaces []string `json:"namespaces,omitempty"`
+
+	// Kinds the rendered manifests may contain
+	// +optional
+	AllowedKinds []KindRule `json:"allowedKinds,omitempty"`
+
+	// Kinds the rendered manifests may not contain, even when allowed
+	// +optional
+	DeniedKinds []KindRule `json:"deniedKinds,omitempty"`
+}
+
+// ChartRule allows a chart, optionally limited to a range of versions
+type ChartRule struct {
+	// Name of the chart in its Chart.yaml, as a glob pattern
+	Name string `json:"name"`
+
+	// Semver range the version of the chart must be in, with the syntax of
+	// the version of a Chart such as ">=1.2 <2" or ~1.2, any version when empty
+	// +optional
+	Versions string `json:"versions,omitempty"`
+}
+
+// KindRule matches the kinds of rendered resources
+type KindRule struct {
+	// API group of the kind, empty for the core group and * for any group
+	// +optional
+	APIGroup string `json:"apiGroup,omitempty"`
+
+	// Kind, as a glob pattern
+	Kind string `json:"kind"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:resource:path=chartpolicies,scope=Cluster
+
+// ChartPolicy restricts the repositories, charts, namespaces and kinds of
+// the charts it applies to. A chart has to comply with every policy that
+// applies to it before anything is applied
+type ChartPolicy struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec ChartPolicySpec `json:"spec,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// ChartPolicyList contains a list of ChartPolicy
+type ChartPolicyList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []ChartPolicy `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&ChartPolicy{}, &ChartPolicyList{})
+}
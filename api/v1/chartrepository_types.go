@@ -0,0 +1,92 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ChartRepositorySpec defines the desired state of ChartRepository
+type ChartRepositorySpec struct {
+	// URL of the chart repository, the index is fetched from <url>/index.yaml,
+	// OCI registry paths given as oci://registry/path have no index
+	URL string `json:"url"`
+
+	// Secret holding the credentials of the repository, either under the
+	// username and password keys or as a docker config for OCI registries
+	// +optional
+	SecretRef *corev1.SecretReference `json:"secretRef,omitempty"`
+
+	// PEM encoded CA bundle used to verify the repository certificate
+	// +optional
+	CABundle []byte `json:"caBundle,omitempty"`
+
+	// How often the index is fetched, defaults to 10m
+	// +optional
+	Interval *metav1.Duration `json:"interval,omitempty"`
+}
+
+// ChartRepository condition types
+const (
+	// The index of the repository was fetched on the last sync
+	RepositoryReady = "Ready"
+)
+
+// ChartRepositoryStatus defines the observed state of ChartRepository
+type ChartRepositoryStatus struct {
+	// Generation of the spec the status was computed for
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// Conditions of the repository
+	// +optional
+	Conditions []Condition `json:"conditions,omitempty"`
+
+	// Last time the index was fetched successfully
+	// +optional
+	LastSyncTime *metav1.Time `json:"lastSyncTime,omitempty"`
+
+	// Number of charts listed in the index
+	// +optional
+	ChartCount int `json:"chartCount,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:resource:path=chartrepositories,scope=Cluster
+// +kubebuilder:subresource:status
+
+// ChartRepository is the Schema for the chartrepositories API
+type ChartRepository struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   ChartRepositorySpec   `json:"spec,omitempty"`
+	Status ChartRepositoryStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// ChartRepositoryList contains a list of ChartRepository
+type ChartRepositoryList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []ChartRepository `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&ChartRepository{}, &ChartRepositoryList{})
+}
@@ -0,0 +1,86 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Condition describes one aspect of the state of an object
+type Condition struct {
+	// Type of the condition
+	Type string `json:"type"`
+
+	// Status of the condition, one of True, False or Unknown
+	Status corev1.ConditionStatus `json:"status"`
+
+	// Last time the condition changed status
+	// +optional
+	LastTransitionTime metav1.Time `json:"lastTransitionTime,omitempty"`
+
+	// Machine readable reason of the last transition
+	// +optional
+	Reason string `json:"reason,omitempty"`
+
+	// Human readable message about the last transition
+	// +optional
+	Message string `json:"message,omitempty"`
+}
+
+// SetCondition adds the condition to the list or updates the existing
+// condition of the same type, the transition time only moves when the
+// status changes
+func SetCondition(conditions *[]Condition, condition Condition) {
+	for i := range *conditions {
+		existing := &(*conditions)[i]
+		if existing.Type != condition.Type {
+			continue
+		}
+		if existing.Status != condition.Status {
+			existing.Status = condition.Status
+			existing.LastTransitionTime = metav1.Now()
+		}
+		existing.Reason = condition.Reason
+		existing.Message = condition.Message
+		return
+	}
+	if condition.LastTransitionTime.IsZero() {
+		condition.LastTransitionTime = metav1.Now()
+	}
+	*conditions = append(*conditions, condition)
+}
+
+// FindCondition returns the condition of the given type, or nil
+func FindCondition(conditions []Condition, conditionType string) *Condition {
+	for i := range conditions {
+		if conditions[i].Type == conditionType {
+			return &conditions[i]
+		}
+	}
+	return nil
+}
+
+// RemoveCondition removes the condition of the given type from the list
+func RemoveCondition(conditions *[]Condition, conditionType string) {
+	var kept []Condition
+	for _, c := range *conditions {
+		if c.Type != conditionType {
+			kept = append(kept, c)
+		}
+	}
+	*conditions = kept
+}
@@ -0,0 +1,49 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// +kubebuilder:object:root=true
+// +kubebuilder:resource:path=namespacedcharts,scope=Namespaced
+// +kubebuilder:subresource:status
+
+// NamespacedChart is a Chart that can only deploy into its own namespace, so
+// it can be managed with namespace RBAC. nameSpaceSelector defaults to the
+// namespace of the chart, and every namespace the chart refers to must be its
+// own
+type NamespacedChart struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   ChartSpec   `json:"spec,omitempty"`
+	Status ChartStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// NamespacedChartList contains a list of NamespacedChart
+type NamespacedChartList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []NamespacedChart `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&NamespacedChart{}, &NamespacedChartList{})
+}
@@ -0,0 +1,105 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// Paths the webhooks of NamespacedChart are served at
+const (
+	namespacedChartDefaultingPath = "/mutate-stable-helm-operator-io-v1-namespacedchart"
+	namespacedChartValidatingPath = "/validate-stable-helm-operator-io-v1-namespacedchart"
+)
+
+// SetupWebhookWithManager registers the defaulting and validating webhooks
+// of NamespacedChart with the webhook server of the manager
+func (r *NamespacedChart) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	server := mgr.GetWebhookServer()
+	server.Register(namespacedChartDefaultingPath, admission.DefaultingWebhookFor(r))
+	server.Register(namespacedChartValidatingPath, admission.ValidatingWebhookFor(r))
+	return nil
+}
+
+// +kubebuilder:webhook:path=/mutate-stable-helm-operator-io-v1-namespacedchart,mutating=true,failurePolicy=fail,groups=stable.helm.operator.io,resources=namespacedcharts,verbs=create;update,versions=v1,name=mnamespacedchart.kb.io
+
+var _ admission.Defaulter = &NamespacedChart{}
+
+// Default defaults the spec like that of a Chart, an empty nameSpaceSelector
+// is left for the controller to read as the namespace of the chart
+func (r *NamespacedChart) Default() {
+	defaultChartSpec(&r.Spec)
+}
+
+// +kubebuilder:webhook:path=/validate-stable-helm-operator-io-v1-namespacedchart,mutating=false,failurePolicy=fail,groups=stable.helm.operator.io,resources=namespacedcharts,verbs=create;update,versions=v1,name=vnamespacedchart.kb.io
+
+var _ admission.Validator = &NamespacedChart{}
+
+// ValidateCreate rejects the specs a Chart would be rejected for and
+// references to namespaces other than that of the chart
+func (r *NamespacedChart) ValidateCreate() error {
+	specPath := field.NewPath("spec")
+	errs := validateChartSpec(&r.Spec, specPath)
+	return r.invalid(append(errs, r.validateNamespaces(specPath)...))
+}
+
+// ValidateUpdate rejects the updates a Chart would be rejected for and
+// references to namespaces other than that of the chart
+func (r *NamespacedChart) ValidateUpdate(old runtime.Object) error {
+	oldChart, ok := old.(*NamespacedChart)
+	if !ok || equality.Semantic.DeepEqual(oldChart.Spec, r.Spec) {
+		return nil
+	}
+	specPath := field.NewPath("spec")
+	errs := validateChartUpdate(&r.Spec, &oldChart.Spec, specPath)
+	return r.invalid(append(errs, r.validateNamespaces(specPath)...))
+}
+
+// Checks the spec only refers to the namespace of the chart, as the
+// controller does before deploying it
+func (r *NamespacedChart) validateNamespaces(specPath *field.Path) field.ErrorList {
+	own := r.Namespace
+	if own == "" {
+		return nil
+	}
+	var errs field.ErrorList
+	forbidden := func(fieldPath *field.Path, namespace string) {
+		if namespace != "" && namespace != own {
+			errs = append(errs, field.Invalid(fieldPath, namespace, "namespaced charts can only use their own namespace "+own))
+		}
+	}
+	forbidden(specPath.Child("nameSpaceSelector"), r.Spec.NameSpaceSelector)
+	if ref := r.Spec.SecretRef; ref != nil {
+		forbidden(specPath.Child("secretRef", "namespace"), ref.Namespace)
+	}
+	for i, ref := range r.Spec.ValuesFrom {
+		forbidden(specPath.Child("valuesFrom").Index(i).Child("namespace"), ref.Namespace)
+	}
+	return errs
+}
+
+// Returns the errors as an Invalid error of the chart
+func (r *NamespacedChart) invalid(errs field.ErrorList) error {
+	if len(errs) == 0 {
+		return nil
+	}
+	return apierrors.NewInvalid(GroupVersion.WithKind("NamespacedChart").GroupKind(), r.Name, errs)
+}
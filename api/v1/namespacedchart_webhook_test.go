@@ -0,0 +1,91 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"golang.org/x/net/context"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+var _ = Describe("NamespacedChart webhooks", func() {
+	var chart *NamespacedChart
+
+	BeforeEach(func() {
+		chart = &NamespacedChart{
+			ObjectMeta: metav1.ObjectMeta{Name: "webhook", Namespace: "default"},
+			Spec: ChartSpec{
+				Chart:   "nginx-ingress",
+				Version: "1.1.0",
+			},
+		}
+	})
+
+	AfterEach(func() {
+		k8sClient.Delete(context.TODO(), chart)
+	})
+
+	// Creates the chart and returns the causes it was rejected for
+	rejected := func() []string {
+		err := k8sClient.Create(context.TODO(), chart)
+		Expect(apierrors.IsInvalid(err)).To(BeTrue(), "expected an invalid error, got %v", err)
+		var fields []string
+		for _, cause := range err.(apierrors.APIStatus).Status().Details.Causes {
+			fields = append(fields, cause.Field)
+		}
+		return fields
+	}
+
+	It("should default repo and leave nameSpaceSelector to the controller", func() {
+		Expect(k8sClient.Create(context.TODO(), chart)).To(Succeed())
+
+		fetched := &NamespacedChart{}
+		Expect(k8sClient.Get(context.TODO(), types.NamespacedName{Namespace: "default", Name: "webhook"}, fetched)).To(Succeed())
+		Expect(fetched.Spec.Repo).To(Equal("stable"))
+		Expect(fetched.Spec.NameSpaceSelector).To(BeEmpty())
+	})
+
+	It("should reject the specs a Chart is rejected for", func() {
+		chart.Spec.Version = "latest"
+		chart.Spec.Repo = "bitnami"
+		Expect(rejected()).To(ConsistOf("spec.version", "spec.repo"))
+	})
+
+	It("should reject other namespaces", func() {
+		chart.Spec.NameSpaceSelector = "kube-system"
+		chart.Spec.SecretRef = &corev1.SecretReference{Name: "creds", Namespace: "kube-system"}
+		chart.Spec.ValuesFrom = []ValuesReference{{Kind: "ConfigMap", Name: "values", Namespace: "kube-system"}}
+		Expect(rejected()).To(ConsistOf("spec.nameSpaceSelector", "spec.secretRef.namespace", "spec.valuesFrom[0].namespace"))
+
+		chart.Spec.NameSpaceSelector = "default"
+		chart.Spec.SecretRef.Namespace = ""
+		chart.Spec.ValuesFrom[0].Namespace = "default"
+		Expect(k8sClient.Create(context.TODO(), chart)).To(Succeed())
+	})
+
+	It("should reject changes of nameSpaceSelector", func() {
+		chart.Spec.NameSpaceSelector = "default"
+		Expect(k8sClient.Create(context.TODO(), chart)).To(Succeed())
+
+		chart.Spec.NameSpaceSelector = ""
+		Expect(apierrors.IsInvalid(k8sClient.Update(context.TODO(), chart))).To(BeTrue())
+	})
+})
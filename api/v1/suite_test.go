@@ -16,14 +16,30 @@ limitations under the License.
 package v1
 
 import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 
+	"golang.org/x/net/context"
+	admissionv1beta1 "k8s.io/api/admissionregistration/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes/scheme"
 	"k8s.io/client-go/rest"
+	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/envtest"
 	logf "sigs.k8s.io/controller-runtime/pkg/log"
@@ -36,6 +52,8 @@ import (
 var cfg *rest.Config
 var k8sClient client.Client
 var testEnv *envtest.Environment
+var stopWebhooks chan struct{}
+var certDir string
 
 func TestAPIs(t *testing.T) {
 	RegisterFailHandler(Fail)
@@ -64,11 +82,117 @@ var _ = BeforeSuite(func(done Done) {
 	Expect(err).ToNot(HaveOccurred())
 	Expect(k8sClient).ToNot(BeNil())
 
+	By("serving the webhooks")
+	startWebhooks()
+
 	close(done)
 }, 60)
 
 var _ = AfterSuite(func() {
 	By("tearing down the test environment")
+	// BeforeSuite may have failed before the webhooks were started
+	if stopWebhooks != nil {
+		close(stopWebhooks)
+	}
+	if certDir != "" {
+		os.RemoveAll(certDir)
+	}
 	err := testEnv.Stop()
 	Expect(err).ToNot(HaveOccurred())
 })
+
+// Serves the webhooks from a manager on localhost and registers them with
+// the API server of the test environment
+func startWebhooks() {
+	var err error
+	certDir, err = ioutil.TempDir("", "webhook-certs-")
+	Expect(err).NotTo(HaveOccurred())
+	caBundle := writeServingCert(certDir)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	Expect(err).NotTo(HaveOccurred())
+	port := l.Addr().(*net.TCPAddr).Port
+	Expect(l.Close()).To(Succeed())
+
+	mgr, err := ctrl.NewManager(cfg, ctrl.Options{
+		Scheme:             scheme.Scheme,
+		MetricsBindAddress: "0",
+		Host:               "127.0.0.1",
+		Port:               port,
+	})
+	Expect(err).NotTo(HaveOccurred())
+	mgr.GetWebhookServer().CertDir = certDir
+	Expect((&Chart{}).SetupWebhookWithManager(mgr)).To(Succeed())
+	Expect((&NamespacedChart{}).SetupWebhookWithManager(mgr)).To(Succeed())
+
+	stopWebhooks = make(chan struct{})
+	go func() {
+		defer GinkgoRecover()
+		Expect(mgr.Start(stopWebhooks)).To(Succeed())
+	}()
+	Eventually(func() error {
+		conn, err := tls.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", port), &tls.Config{InsecureSkipVerify: true})
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}, 10*time.Second).Should(Succeed())
+
+	failurePolicy := admissionv1beta1.Fail
+	webhook := func(name, path, resource string) admissionv1beta1.Webhook {
+		url := fmt.Sprintf("https://127.0.0.1:%d%s", port, path)
+		return admissionv1beta1.Webhook{
+			Name:          name,
+			ClientConfig:  admissionv1beta1.WebhookClientConfig{URL: &url, CABundle: caBundle},
+			FailurePolicy: &failurePolicy,
+			Rules: []admissionv1beta1.RuleWithOperations{{
+				Operations: []admissionv1beta1.OperationType{admissionv1beta1.Create, admissionv1beta1.Update},
+				Rule: admissionv1beta1.Rule{
+					APIGroups:   []string{GroupVersion.Group},
+					APIVersions: []string{GroupVersion.Version},
+					Resources:   []string{resource},
+				},
+			}},
+		}
+	}
+	Expect(k8sClient.Create(context.TODO(), &admissionv1beta1.MutatingWebhookConfiguration{
+		ObjectMeta: metav1.ObjectMeta{Name: "mutating-webhook-configuration"},
+		Webhooks: []admissionv1beta1.Webhook{
+			webhook("mchart.kb.io", chartDefaultingPath, "charts"),
+			webhook("mnamespacedchart.kb.io", namespacedChartDefaultingPath, "namespacedcharts"),
+		},
+	})).To(Succeed())
+	Expect(k8sClient.Create(context.TODO(), &admissionv1beta1.ValidatingWebhookConfiguration{
+		ObjectMeta: metav1.ObjectMeta{Name: "validating-webhook-configuration"},
+		Webhooks: []admissionv1beta1.Webhook{
+			webhook("vchart.kb.io", chartValidatingPath, "charts"),
+			webhook("vnamespacedchart.kb.io", namespacedChartValidatingPath, "namespacedcharts"),
+		},
+	})).To(Succeed())
+}
+
+// Writes a self-signed serving certificate for 127.0.0.1 to dir and returns
+// it PEM encoded as the CA bundle of the webhooks
+func writeServingCert(dir string) []byte {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	Expect(err).NotTo(HaveOccurred())
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "127.0.0.1"},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	Expect(err).NotTo(HaveOccurred())
+	cert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	Expect(ioutil.WriteFile(filepath.Join(dir, "tls.crt"), cert, 0600)).To(Succeed())
+	Expect(ioutil.WriteFile(filepath.Join(dir, "tls.key"), pem.EncodeToMemory(&pem.Block{
+		Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key),
+	}), 0600)).To(Succeed())
+	return cert
+}
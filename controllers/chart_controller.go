@@ -18,24 +18,34 @@ package controllers
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	stablev1 "github.com/Spazzy757/helm-operator/api/v1"
+	"github.com/Spazzy757/helm-operator/render"
+	"github.com/Spazzy757/helm-operator/repository"
 	"github.com/go-logr/logr"
-	"os"
 	"strings"
 	//"io"
 	corev1 "k8s.io/api/core/v1"
 	apierrs "k8s.io/apimachinery/pkg/api/errors"
-	//metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
 	//"k8s.io/apimachinery/pkg/runtime/schema"
 	//ref "k8s.io/client-go/tools/reference"
 	//"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/rest"
 	ref "k8s.io/client-go/tools/reference"
-	"os/exec"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 	"sigs.k8s.io/yaml"
 )
 
@@ -44,123 +54,376 @@ type ChartReconciler struct {
 	client.Client
 	Log    logr.Logger
 	Scheme *runtime.Scheme
+	// Renderer used to template charts, defaults to the in-process engine
+	Renderer render.Renderer
+	// Client used to fetch charts from repositories
+	Repositories *repository.Client
+	// Indexes of the ChartRepository resources, shared with their reconciler
+	RepositoryCache *repository.Cache
+	// Client used to check charts out of git sources
+	Git *repository.GitClient
+	// Used to tell namespaced from cluster-scoped kinds, defaults to the
+	// mapper of the manager
+	Mapper meta.RESTMapper
+	// Health checks by kind, these take precedence over the built-in checks
+	HealthChecks map[schema.GroupKind]HealthCheck
+	// Config of the manager, impersonated to act as the service account of a
+	// chart, defaults to the config of the manager
+	Config *rest.Config
+
+	// Watches of the kinds of chart resources, set up with the manager
+	watches *resourceWatches
 }
 
 var ctx = context.Background()
 
+const (
+	// Resources annotated with the keep policy survive pruning and chart deletion
+	resourcePolicyAnnotation = "helm.sh/resource-policy"
+	keepPolicy               = "keep"
+
+	// The rendered manifest holds a document that is not a valid object
+	reasonInvalidManifest = "InvalidManifest"
+)
+
 // +kubebuilder:rbac:groups=stable.helm.operator.io,resources=charts,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=stable.helm.operator.io,resources=charts/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=stable.helm.operator.io,resources=namespacedcharts,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=stable.helm.operator.io,resources=namespacedcharts/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=stable.helm.operator.io,resources=chartrepositories,verbs=get;list;watch
+// +kubebuilder:rbac:groups=stable.helm.operator.io,resources=chartpolicies,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=configmaps,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=secrets,verbs=get;list;watch;create;update;delete
+// +kubebuilder:rbac:groups="",resources=namespaces,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=apiextensions.k8s.io,resources=customresourcedefinitions,verbs=get;list;watch;create;update;patch
+// +kubebuilder:rbac:groups=batch,resources=jobs,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups="",resources=pods,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups="",resources=serviceaccounts,verbs=get;impersonate
 // +kubebuilder:rbac:groups=apps,resources=statefulsets;deployment,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=apps,resources=statefulsets/status;deployment/status,verbs=get;list;watch;create;update;patch;delete
 func (r *ChartReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 
 	log := r.Log.WithValues("chart", req.NamespacedName)
-	instance := &stablev1.Chart{}
 	finalizer := "helm.operator.finalizer.io"
 	forGroundFinalizer := "foregroundDeletion"
 	// your logic here
 
-	if err := r.Get(ctx, req.NamespacedName, instance); err != nil {
+	// NamespacedCharts are reconciled as Charts
+	instance, err := r.getInstance(req.NamespacedName)
+	if err != nil {
 		return ctrl.Result{}, ignoreNotFound(err)
 	}
 	if instance.ObjectMeta.DeletionTimestamp.IsZero() {
+		// Resources of the chart are managed as its service account
+		rc, err := r.forServiceAccount(instance)
+		if err != nil {
+			log.Error(err, "unable to impersonate service account")
+			return r.failed(instance, stablev1.ChartApplied, err)
+		}
 		if !containsString(instance.ObjectMeta.Finalizers, finalizer) {
 			instance.ObjectMeta.Finalizers = append(instance.ObjectMeta.Finalizers, finalizer)
-			if err := r.Update(context.Background(), instance); err != nil {
+			if err := r.updateFinalizers(instance); err != nil {
 				return ctrl.Result{}, err
 			}
 		}
-		if err := getChart(instance); err != nil {
-			return ctrl.Result{}, err
+		if err := checkNamespaces(instance); err != nil {
+			log.Error(err, "chart refers to another namespace")
+			return rc.failed(instance, stablev1.ChartApplied, err)
 		}
-		yamlString, err := templateChart(instance)
+		chartPath, commit, err := rc.getChart(instance)
 		if err != nil {
-			return ctrl.Result{}, err
+			log.Error(err, "unable to fetch chart")
+			return rc.failed(instance, stablev1.ChartFetched, err)
 		}
-		resources := bytes.Split(yamlString, []byte(`---`))
-		// your logic here
-		for _, resource := range resources {
-			// Helm sometimes templates just comments so skip these
-			if !strings.Contains(string(resource), "kind") {
-				continue
-			}
-			// Decode the YAML to an object.
-			u := &unstructured.Unstructured{Object: map[string]interface{}{}}
-			if err := yaml.Unmarshal(resource, &u.Object); err != nil {
-				fmt.Println(err)
-			}
+		metadata, revision, err := chartRevision(chartPath, commit)
+		if err != nil {
+			log.Error(err, "unable to load chart")
+			return rc.failed(instance, stablev1.ChartFetched, err)
+		}
+		instance.Status.LastAttemptedRevision = revision
+		fetched := fmt.Sprintf("Fetched revision %v", revision)
+		if available := instance.Status.AvailableVersion; available != "" {
+			fetched += fmt.Sprintf(", version %v waits for approval", available)
+		}
+		succeeded(instance, stablev1.ChartFetched, "Fetched", fetched)
+
+		rendered, err := rc.templateChart(instance, chartPath)
+		if err != nil {
+			log.Error(err, "unable to render chart")
+			return rc.failed(instance, stablev1.ChartRendered, err)
+		}
+		rendered.chartRevision = revision
+		rendered.chart, rendered.chartVersion = metadata.Name, metadata.Version
+		succeeded(instance, stablev1.ChartRendered, "Rendered", fmt.Sprintf("Rendered revision %v", revision))
+
+		// Deploy a previous revision instead when rolled back
+		rel, err := rc.targetRelease(instance, rendered)
+		if err != nil {
+			log.Error(err, "unable to read release revision")
+			return rc.failed(instance, stablev1.ChartApplied, err)
+		}
+
+		// Nothing is applied while a ChartPolicy does not allow the release
+		if err := r.checkPolicies(instance, rel); err != nil {
+			log.Error(err, "chart is not allowed by policy")
+			return rc.failed(instance, stablev1.ChartApplied, err)
+		}
+
+		// Only preview the changes while applying is suspended
+		if instance.Spec.SuspendApply {
+			return rc.previewRelease(instance, rel)
+		}
+
+		if err := rc.ensureNamespace(instance); err != nil {
+			log.Error(err, "unable to create namespace")
+			return rc.failed(instance, stablev1.ChartApplied, err)
+		}
+		// A release that used up its retries waits for the chart or values to change
+		if releaseStalled(instance, rel) {
+			log.V(1).Info("retries exhausted, waiting for the chart or values to change")
+			return ctrl.Result{}, nil
+		}
+		instance.Status.LastAttemptedRevision = rel.chartRevision
+		if err := rc.recordRevision(instance, rel); err != nil {
+			log.Error(err, "unable to record release revision")
+			return rc.failed(instance, stablev1.ChartApplied, err)
+		}
+		objects, err := decodeManifest(rel.manifest)
+		if err != nil {
+			log.Error(err, "unable to decode release manifest")
+			return rc.failed(instance, stablev1.ChartApplied, err)
+		}
+		// Apply dependencies such as ServiceAccounts and ConfigMaps before the workloads using them
+		sortForInstall(objects)
+		// Hooks are run around the release instead of being applied with it
+		hooks, objects := splitHooks(objects)
+
+		// CRDs go first so resources of the kinds they define can be applied
+		crds, objects := splitCRDs(objects)
+		if established, err := rc.applyCRDs(instance, crds); err != nil {
+			log.Error(err, "unable to apply CRDs")
+			return rc.failed(instance, stablev1.ChartApplied, err)
+		} else if !established {
+			return rc.progressing(instance, "Waiting for CRDs to be established", crdPollInterval)
+		}
+
+		// Hooks only run when the chart or its values changed since the last deploy
+		preHook, postHook := hookPreInstall, hookPostInstall
+		switch {
+		case rel.rollbackOf != 0:
+			preHook, postHook = hookPreRollback, hookPostRollback
+		case instance.Status.DeployedDigest != "":
+			preHook, postHook = hookPreUpgrade, hookPostUpgrade
+		}
+		if rel.digest == instance.Status.DeployedDigest {
+			hooks = nil
+		}
+		if done, err := rc.runHooks(instance, hooks, preHook, rel.digest); err != nil {
+			log.Error(err, "unable to run hooks", "hook", preHook)
+			return rc.failed(instance, stablev1.ChartApplied, err)
+		} else if !done {
+			return rc.progressing(instance, fmt.Sprintf("Waiting for %v hooks", preHook), hookPollInterval)
+		}
+
+		// Changes made outside of the operator are only drift while the release is deployed
+		mode := driftMode(instance)
+		detectDrift := mode != stablev1.DriftDetectionDisabled && rel.digest == instance.Status.DeployedDigest
+		var drift []stablev1.DriftedResource
+		// resources left deleted in warn mode, they are not waited for
+		var missing []corev1.ObjectReference
+
+		// references of everything applied in this pass, used to prune orphans
+		var applied []corev1.ObjectReference
+		for _, u := range objects {
 			// set controller reference
-			if err := ctrl.SetControllerReference(instance, u, r.Scheme); err != nil {
-				return ctrl.Result{}, err
+			if err := setOwner(instance, u, rc.Scheme); err != nil {
+				return rc.failed(instance, stablev1.ChartApplied, err)
 			}
 
-			// set namespace of the resource (by default helm does not template this out)
-			u.SetNamespace(instance.Spec.NameSpaceSelector)
+			// set namespace of namespaced resources (by default helm does not template this out)
+			if err := rc.setNamespace(instance, u); err != nil {
+				log.Error(err, "unable to scope resource", "Object", u.GetName())
+				return rc.failed(instance, stablev1.ChartApplied, err)
+			}
 			// Get the reference of the resource to attach to the chart instance
-			objRef, err := ref.GetReference(r.Scheme, u)
+			objRef, err := ref.GetReference(rc.Scheme, u)
 			if err != nil {
 				log.Error(err, "unable to make reference", "Object", u.GetName())
 			}
+			// Record the rendered manifest so the next update can compute a three-way merge
+			if err := setLastApplied(u); err != nil {
+				return rc.failed(instance, stablev1.ChartApplied, err)
+			}
 			// Get Key to fetch resource if exists
 			key, err := client.ObjectKeyFromObject(u)
 			if err != nil {
-				return ctrl.Result{}, err
+				return rc.failed(instance, stablev1.ChartApplied, err)
 			}
 
 			// Get resource
-			if err := r.Client.Get(ctx, key, u); err != nil {
+			live := &unstructured.Unstructured{}
+			live.SetGroupVersionKind(u.GroupVersionKind())
+			if err := rc.Client.Get(ctx, key, live); err != nil {
 				// if error is anything but is not found, return error
 				if !apierrs.IsNotFound(err) {
 					log.Error(err, "unable to get object, unknown error occured")
-					return ctrl.Result{}, err
+					return rc.failed(instance, stablev1.ChartApplied, err)
+				}
+
+				if detectDrift && refInSlice(*objRef, instance.Status.Resource) {
+					drift = append(drift, drifted(*objRef, stablev1.DriftDeleted, nil))
+					if mode == stablev1.DriftDetectionWarn {
+						applied = append(applied, *objRef)
+						missing = append(missing, *objRef)
+						continue
+					}
 				}
 
 				// set finalizer of resource
 				u.SetFinalizers([]string{forGroundFinalizer})
 
 				// Create Object
-				if err := r.Create(ctx, u); err != nil {
+				if err := rc.Create(ctx, u); err != nil {
 					log.Error(err, fmt.Sprintf("unable to apply %v", u.GroupVersionKind()))
-					instance.Status.Status = "Failed"
-					if err := r.UpdateStatus(instance); err != nil {
-						return ctrl.Result{}, err
-					}
-					return ctrl.Result{}, err
+					return rc.failed(instance, stablev1.ChartApplied, err)
 				}
 				log.V(1).Info(fmt.Sprintf("Applying: %v", u.GroupVersionKind()))
-
-				// Check if resource reference is attached to instance, if not add it
-				if !refInSlice(*objRef, instance.Status.Resource) {
-					instance.Status.Resource = append(instance.Status.Resource, *objRef)
-					if err := r.UpdateStatus(instance); err != nil {
-						return ctrl.Result{}, err
+			} else {
+				// Fields left to other controllers keep their live value
+				if err := keepIgnoredFields(ignoredPaths(instance, u), live, u); err != nil {
+					return rc.failed(instance, stablev1.ChartApplied, err)
+				}
+				// Patch only the fields the chart owns
+				patchType, patch, err := threeWayMergePatch(rc.Scheme, live, u)
+				if err != nil {
+					log.Error(err, fmt.Sprintf("unable to compute patch for %v", u.GroupVersionKind()))
+					return rc.failed(instance, stablev1.ChartApplied, err)
+				}
+				if detectDrift && !isEmptyPatch(patch) {
+					drift = append(drift, drifted(*objRef, stablev1.DriftModified, patch))
+					if mode == stablev1.DriftDetectionWarn {
+						patch = nil
+					}
+				}
+				if patch != nil && !isEmptyPatch(patch) {
+					if err := rc.Patch(ctx, live, client.ConstantPatch(patchType, patch)); err != nil {
+						log.Error(err, fmt.Sprintf("unable to update %v", u.GroupVersionKind()))
+						return rc.failed(instance, stablev1.ChartApplied, err)
 					}
+					log.V(1).Info(fmt.Sprintf("Updating: %v", u.GroupVersionKind()))
+				}
+			}
+
+			applied = append(applied, *objRef)
+			// Check if resource reference is attached to instance, if not add it
+			if !refInSlice(*objRef, instance.Status.Resource) {
+				instance.Status.Resource = append(instance.Status.Resource, *objRef)
+				if err := rc.UpdateStatus(instance); err != nil {
+					return ctrl.Result{}, err
 				}
-				continue
 			}
-			continue
-			// Implement Patch if resource already exist
-			//log.V(1).Info(fmt.Sprintf("Updating: %v", u.GroupVersionKind()))
 		}
 
+		// Remove whatever the chart no longer renders
+		if err := rc.pruneResources(instance, applied); err != nil {
+			log.Error(err, "unable to prune resources")
+			return rc.failed(instance, stablev1.ChartApplied, err)
+		}
+		succeeded(instance, stablev1.ChartApplied, "Applied", fmt.Sprintf("Applied %d resources", len(applied)))
+		reportDrift(instance, drift)
+		if mode != stablev1.DriftDetectionDisabled {
+			rc.watchResources(applied)
+		}
+
+		// Workloads have to roll out before the chart is reported deployed
+		var present []corev1.ObjectReference
+		for _, resource := range applied {
+			if !refInSlice(resource, missing) {
+				present = append(present, resource)
+			}
+		}
+		if healthy, err := rc.checkHealth(instance, present); err != nil {
+			log.Error(err, "resources are not healthy")
+			return rc.failedUpgrade(instance, stablev1.ChartReady, rel, err)
+		} else if !healthy {
+			message := "Waiting for resources to become healthy: " + describeHealth(instance.Status.ResourceHealth, stablev1.HealthProgressing)
+			return rc.progressing(instance, message, healthPollInterval)
+		}
+
+		if done, err := rc.runHooks(instance, hooks, postHook, rel.digest); err != nil {
+			log.Error(err, "unable to run hooks", "hook", postHook)
+			return rc.failedUpgrade(instance, stablev1.ChartApplied, rel, err)
+		} else if !done {
+			return rc.progressing(instance, fmt.Sprintf("Waiting for %v hooks", postHook), hookPollInterval)
+		}
+
+		deployedRevision(instance)
+		switch {
+		case rel.rollbackOf == 0:
+			succeeded(instance, stablev1.ChartReady, reasonHealthy, fmt.Sprintf("Deployed revision %v", rel.chartRevision))
+			stablev1.RemoveCondition(&instance.Status.Conditions, stablev1.ChartStalled)
+			instance.Status.RolledBackDigest = ""
+		case instance.Spec.Rollback != nil && instance.Spec.Rollback.Revision != 0:
+			succeeded(instance, stablev1.ChartReady, reasonRolledBack, fmt.Sprintf("Rolled back to revision %d", rel.rollbackOf))
+			stablev1.RemoveCondition(&instance.Status.Conditions, stablev1.ChartStalled)
+		default:
+			// a rollback after the retries were used up stays stalled
+			// the chart and values asked for are not deployed
+			stablev1.SetCondition(&instance.Status.Conditions, stablev1.Condition{
+				Type:    stablev1.ChartReady,
+				Status:  corev1.ConditionFalse,
+				Reason:  reasonRolledBack,
+				Message: fmt.Sprintf("Upgrade failed and was rolled back to revision %d, change the chart or values to upgrade again", rel.rollbackOf),
+			})
+		}
 		instance.Status.Status = "Deployed"
-		if err := r.UpdateStatus(instance); err != nil {
+		instance.Status.ObservedGeneration = instance.GetGeneration()
+		instance.Status.LastAppliedRevision = rel.chartRevision
+		instance.Status.GitCommit = commit
+		instance.Status.DeployedDigest = rel.digest
+		instance.Status.Diff = nil
+		if err := rc.UpdateStatus(instance); err != nil {
 			return ctrl.Result{}, err
 		}
 		log.V(1).Info("reconciling the Chart")
+		// newly published versions in the range are picked up on the next sync
+		if repository.IsRange(instance.Spec.Version) && instance.Spec.Source == nil {
+			return ctrl.Result{RequeueAfter: versionSyncInterval}, nil
+		}
 		return ctrl.Result{}, nil
 	} else {
 		if containsString(instance.ObjectMeta.Finalizers, finalizer) {
+			rc := r.forDeletion(instance)
 			// our finalizer is present, so lets handle any external dependency
-			if err := r.deleteExternalResources(instance); err != nil {
+			hooks, digest, err := rc.deleteHooks(instance)
+			if err != nil {
+				// a chart that can no longer be rendered must still be deletable
+				log.Error(err, "unable to render delete hooks, deleting without them")
+			}
+			if done, err := rc.runHooks(instance, hooks, hookPreDelete, digest); err != nil {
+				log.Error(err, "unable to run hooks", "hook", hookPreDelete)
+				return ctrl.Result{}, err
+			} else if !done {
+				return ctrl.Result{RequeueAfter: hookPollInterval}, nil
+			}
+			if err := rc.deleteExternalResources(instance); err != nil {
 				// if fail to delete the external dependency here, return with error
 				// so that it can be retried
 				return ctrl.Result{}, err
 			}
+			if done, err := rc.runHooks(instance, hooks, hookPostDelete, digest); err != nil {
+				log.Error(err, "unable to run hooks", "hook", hookPostDelete)
+				return ctrl.Result{}, err
+			} else if !done {
+				return ctrl.Result{RequeueAfter: hookPollInterval}, nil
+			}
+			if err := rc.deleteNamespace(instance); err != nil {
+				return ctrl.Result{}, err
+			}
 
 			// remove our finalizer from the list and update it.
 			instance.ObjectMeta.Finalizers = removeString(instance.ObjectMeta.Finalizers, finalizer)
-			if err := r.Update(context.Background(), instance); err != nil {
+			if err := r.updateFinalizers(instance); err != nil {
 				return ctrl.Result{}, err
 			}
 		}
@@ -168,100 +431,320 @@ func (r *ChartReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 	return ctrl.Result{}, nil
 }
 
-// Deletes all resources attached to the instance
+// Deletes all resources attached to the instance in uninstall order
 func (r *ChartReconciler) deleteExternalResources(instance *stablev1.Chart) error {
-	for _, resource := range instance.Status.Resource {
-		u := &unstructured.Unstructured{}
-		u.Object = map[string]interface{}{
-			"metadata": map[string]interface{}{
-				"name":      resource.Name,
-				"namespace": resource.Namespace,
-			},
-		}
-		key, err := client.ObjectKeyFromObject(u)
-		if err != nil {
+	for _, resource := range sortForUninstall(instance.Status.Resource) {
+		if err := r.deleteResource(instance, resource); err != nil {
 			return err
 		}
-		u.SetGroupVersionKind(resource.GroupVersionKind())
-		if err := r.Get(ctx, key, u); err != nil {
-			return err
+	}
+	return nil
+}
+
+// Deletes resources attached to the instance that are no longer rendered by
+// the chart and replaces the status list with the rendered set
+func (r *ChartReconciler) pruneResources(instance *stablev1.Chart, rendered []corev1.ObjectReference) error {
+	for _, resource := range sortForUninstall(instance.Status.Resource) {
+		if refInSlice(resource, rendered) {
+			continue
 		}
-		if err := r.Delete(ctx, u); err != nil {
+		if err := r.deleteResource(instance, resource); err != nil {
 			return err
 		}
+		r.Log.V(1).Info(fmt.Sprintf("Pruned: %v %v", resource.GroupVersionKind(), resource.Name))
 	}
+	instance.Status.Resource = rendered
 	return nil
 }
 
+// Deletes a single resource, resources annotated to be kept are instead
+// released from the instance so garbage collection leaves them alone
+func (r *ChartReconciler) deleteResource(instance *stablev1.Chart, resource corev1.ObjectReference) error {
+	u := &unstructured.Unstructured{}
+	u.Object = map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"name":      resource.Name,
+			"namespace": resource.Namespace,
+		},
+	}
+	key, err := client.ObjectKeyFromObject(u)
+	if err != nil {
+		return err
+	}
+	u.SetGroupVersionKind(resource.GroupVersionKind())
+	if err := r.Get(ctx, key, u); err != nil {
+		return ignoreNotFound(err)
+	}
+	// CRDs are released like kept resources, deleting them deletes every resource of their kind
+	if u.GetAnnotations()[resourcePolicyAnnotation] == keepPolicy || resource.Kind == crdKind {
+		var owners []metav1.OwnerReference
+		for _, owner := range u.GetOwnerReferences() {
+			if owner.UID != instance.GetUID() {
+				owners = append(owners, owner)
+			}
+		}
+		if len(owners) == len(u.GetOwnerReferences()) {
+			return nil
+		}
+		u.SetOwnerReferences(owners)
+		return ignoreNotFound(r.Update(ctx, u))
+	}
+	return ignoreNotFound(r.Delete(ctx, u))
+}
+
 func (r *ChartReconciler) SetupWithManager(mgr ctrl.Manager) error {
-	return ctrl.NewControllerManagedBy(mgr).
-		For(&stablev1.Chart{}).
-		Complete(r)
+	if r.Mapper == nil {
+		r.Mapper = mgr.GetRESTMapper()
+	}
+	if r.Config == nil {
+		r.Config = mgr.GetConfig()
+	}
+	// The controller is kept to watch the kinds of chart resources as they are applied
+	c, err := controller.New("chart", mgr, controller.Options{Reconciler: r})
+	if err != nil {
+		return err
+	}
+	if err := c.Watch(&source.Kind{Type: &stablev1.Chart{}}, &handler.EnqueueRequestForObject{}); err != nil {
+		return err
+	}
+	if err := c.Watch(&source.Kind{Type: &stablev1.NamespacedChart{}}, &handler.EnqueueRequestForObject{}); err != nil {
+		return err
+	}
+	if err := c.Watch(&source.Kind{Type: &stablev1.ChartRepository{}}, &handler.EnqueueRequestsFromMapFunc{
+		ToRequests: handler.ToRequestsFunc(r.chartsForRepository),
+	}); err != nil {
+		return err
+	}
+	if err := c.Watch(&source.Kind{Type: &stablev1.ChartPolicy{}}, &handler.EnqueueRequestsFromMapFunc{
+		ToRequests: handler.ToRequestsFunc(r.chartsForPolicy),
+	}); err != nil {
+		return err
+	}
+	if err := c.Watch(&source.Kind{Type: &corev1.ConfigMap{}}, &handler.EnqueueRequestsFromMapFunc{
+		ToRequests: handler.ToRequestsFunc(r.chartsForValues("ConfigMap")),
+	}); err != nil {
+		return err
+	}
+	if err := c.Watch(&source.Kind{Type: &corev1.Secret{}}, &handler.EnqueueRequestsFromMapFunc{
+		ToRequests: handler.ToRequestsFunc(r.chartsForValues("Secret")),
+	}); err != nil {
+		return err
+	}
+	r.watches = &resourceWatches{controller: c}
+	return nil
+}
+
+// Maps a ChartRepository to the charts referencing it so they are retried
+// once its index is synced
+func (r *ChartReconciler) chartsForRepository(o handler.MapObject) []ctrl.Request {
+	charts, err := r.listCharts()
+	if err != nil {
+		r.Log.Error(err, "unable to list charts", "repository", o.Meta.GetName())
+		return nil
+	}
+	var requests []ctrl.Request
+	for _, c := range charts {
+		if c.Spec.RepositoryRef != nil && c.Spec.RepositoryRef.Name == o.Meta.GetName() {
+			requests = append(requests, ctrl.Request{
+				NamespacedName: types.NamespacedName{Namespace: c.GetNamespace(), Name: c.GetName()},
+			})
+		}
+	}
+	return requests
 }
 
 // Updates the status of the instance on the kube api server
 func (r *ChartReconciler) UpdateStatus(c *stablev1.Chart) error {
+	if isNamespaced(c) {
+		nc := toNamespaced(c)
+		if err := r.Status().Update(ctx, nc); err != nil {
+			return err
+		}
+		c.ResourceVersion = nc.ResourceVersion
+		return nil
+	}
 	if err := r.Status().Update(ctx, c); err != nil {
 		return err
 	}
 	return nil
 }
 
-// Fetch the chart specified on the instance
-func getChart(c *stablev1.Chart) error {
-	exec.Command("helm", "repo", "update")
-	if err := os.MkdirAll("chart/"+c.Spec.Version, os.ModePerm); err != nil {
-		fmt.Printf("%v\n", err)
-	}
-	cmd := exec.Command("helm",
-		"fetch",
-		"--untar",
-		"--version="+c.Spec.Version,
-		"--untardir=chart/"+c.Spec.Version,
-		c.Spec.Repo+"/"+c.Spec.Chart)
-	var out bytes.Buffer
-	var stderr bytes.Buffer
-	cmd.Stdout = &out
-	cmd.Stderr = &stderr
-	err := cmd.Run()
+// Fetch the chart specified on the instance and return the path of its archive
+// or directory, for git sources the resolved commit is returned as well
+func (r *ChartReconciler) getChart(c *stablev1.Chart) (string, string, error) {
+	if c.Spec.Source != nil && c.Spec.Source.Git != nil {
+		git := r.Git
+		if git == nil {
+			git = &repository.GitClient{CacheDir: "charts"}
+		}
+		src := c.Spec.Source.Git
+		return git.Checkout(src.URL, src.Ref, src.Path)
+	}
+	chartPath, err := r.fetchChart(c)
+	return chartPath, "", err
+}
+
+// Fetch the chart from its chart repository or OCI registry, version ranges
+// are resolved first and recorded in the status of the instance
+func (r *ChartReconciler) fetchChart(c *stablev1.Chart) (string, error) {
+	if c.Spec.RepositoryRef != nil {
+		var entry *repository.Entry
+		ok := false
+		if r.RepositoryCache != nil {
+			entry, ok = r.RepositoryCache.Get(c.Spec.RepositoryRef.Name)
+		}
+		if !ok {
+			return "", &repository.Error{
+				Reason: repository.ReasonRepositoryNotReady,
+				Err:    fmt.Errorf("chart repository %q does not exist or has not been synced", c.Spec.RepositoryRef.Name),
+			}
+		}
+		version, err := resolveVersion(c, func() ([]string, error) { return entry.ListVersions(c.Spec.Chart) })
+		if err != nil {
+			return "", err
+		}
+		return entry.FetchChart(c.Spec.Chart, version)
+	}
+	repositories := &repository.Client{CacheDir: "charts"}
+	if r.Repositories != nil {
+		copied := *r.Repositories
+		repositories = &copied
+	}
+	if err := setCredentials(r.Client, repositories, c.Spec.SecretRef, c.Spec.Repo); err != nil {
+		return "", err
+	}
+	version, err := resolveVersion(c, func() ([]string, error) { return repositories.ListVersions(c.Spec.Repo, c.Spec.Chart) })
 	if err != nil {
-		fmt.Println(fmt.Sprint(err) + ": " + stderr.String())
-		return err
+		return "", err
 	}
-	return nil
+	return repositories.FetchChart(c.Spec.Repo, c.Spec.Chart, version)
 }
 
-// template out the yaml files from the chart
-func templateChart(c *stablev1.Chart) ([]byte, error) {
-	values := buildValuesString(c)
-	cmd := exec.Command("helm",
-		"template",
-		"--name="+c.GetName(),
-		"--set="+values,
-		"--namespace="+c.Spec.NameSpaceSelector,
-		"chart/"+c.Spec.Version+"/"+c.Spec.Chart)
-	var out bytes.Buffer
-	var stderr bytes.Buffer
-	cmd.Stdout = &out
-	cmd.Stderr = &stderr
-	err := cmd.Run()
+// template out the yaml files from the chart, along with the digests of the
+// chart and values they were rendered from
+func (r *ChartReconciler) templateChart(c *stablev1.Chart, chartPath string) (*release, error) {
+	base, err := r.valuesFrom(c)
 	if err != nil {
-		fmt.Println(fmt.Sprint(err) + ": " + stderr.String())
 		return nil, err
 	}
-	return out.Bytes(), nil
+	values, err := buildValues(c, base)
+	if err != nil {
+		return nil, err
+	}
+	digest, valuesDigest, err := releaseDigest(chartPath, values)
+	if err != nil {
+		return nil, err
+	}
+	var apiVersions []string
+	for _, gv := range r.Scheme.PrioritizedVersionsAllGroups() {
+		apiVersions = append(apiVersions, gv.String())
+	}
+	renderer := r.Renderer
+	if renderer == nil {
+		renderer = render.NewEngine()
+	}
+	manifest, err := renderer.Render(chartPath, render.Options{
+		ReleaseName: c.GetName(),
+		Namespace:   c.Spec.NameSpaceSelector,
+		Values:      values,
+		APIVersions: apiVersions,
+		IsUpgrade:   c.Status.DeployedDigest != "",
+	})
+	if err != nil {
+		return nil, err
+	}
+	if _, err := decodeManifest(manifest); err != nil {
+		return nil, err
+	}
+	return &release{manifest: manifest, digest: digest, valuesDigest: valuesDigest}, nil
 }
 
-// Builds a string representation of the values on the instance
-func buildValuesString(c *stablev1.Chart) string {
-	var buildString string
-	for _, valuePair := range c.Spec.Values {
-		buildString += valuePair.Name + "=" + valuePair.Value + ","
+// Returns a digest of the chart and the values it is rendered with, along
+// with a digest of the values alone. The cached path of a chart changes with
+// its version or commit
+func releaseDigest(chartPath string, values map[string]interface{}) (string, string, error) {
+	b, err := json.Marshal(values)
+	if err != nil {
+		return "", "", err
 	}
-	if last := len(buildString) - 1; last >= 0 && buildString[last] == ',' {
-		buildString = buildString[:last]
+	h := sha256.New()
+	h.Write([]byte(chartPath + "\n"))
+	h.Write(b)
+	valuesSum := sha256.Sum256(b)
+	return hex.EncodeToString(h.Sum(nil)), hex.EncodeToString(valuesSum[:]), nil
+}
+
+// Splits a rendered manifest into objects, a document that cannot be decoded
+// fails the whole manifest
+func decodeManifest(manifest []byte) ([]*unstructured.Unstructured, error) {
+	var objects []*unstructured.Unstructured
+	for _, resource := range bytes.Split(manifest, []byte(`---`)) {
+		// Helm sometimes templates just comments so skip these
+		if !strings.Contains(string(resource), "kind") {
+			continue
+		}
+		// Decode the YAML to an object.
+		u := &unstructured.Unstructured{Object: map[string]interface{}{}}
+		if err := yaml.Unmarshal(resource, &u.Object); err != nil {
+			return nil, &reasonError{
+				reason: reasonInvalidManifest,
+				err:    fmt.Errorf("unable to decode %s: %v", manifestSource(resource), err),
+			}
+		}
+		objects = append(objects, u)
 	}
-	return buildString
+	return objects, nil
+}
+
+// Returns the template a document of a manifest was rendered from
+func manifestSource(resource []byte) string {
+	for _, line := range strings.Split(string(resource), "\n") {
+		if line = strings.TrimSpace(line); strings.HasPrefix(line, "# Source: ") {
+			return strings.TrimPrefix(line, "# Source: ")
+		}
+	}
+	return "manifest"
+}
+
+// Renders the chart of an instance being deleted and returns its hooks
+func (r *ChartReconciler) deleteHooks(c *stablev1.Chart) ([]*unstructured.Unstructured, string, error) {
+	chartPath, _, err := r.getChart(c)
+	if err != nil {
+		return nil, "", err
+	}
+	rel, err := r.templateChart(c, chartPath)
+	if err != nil {
+		return nil, "", err
+	}
+	objects, err := decodeManifest(rel.manifest)
+	if err != nil {
+		return nil, "", err
+	}
+	sortForInstall(objects)
+	hooks, _ := splitHooks(objects)
+	return hooks, rel.digest, nil
+}
+
+// Returns the machine readable reason of a fetch or render failure
+func failureReason(err error) string {
+	if e, ok := err.(*reasonError); ok {
+		return e.reason
+	}
+	if reason := repository.ReasonFor(err); reason != "" {
+		return string(reason)
+	}
+	return string(render.ReasonFor(err))
+}
+
+// reasonError is a failure of the reconciler itself with a machine readable
+// reason for the status
+type reasonError struct {
+	reason string
+	err    error
+}
+
+func (e *reasonError) Error() string {
+	return e.err.Error()
 }
 
 // Ignores not found error
@@ -272,10 +755,33 @@ func ignoreNotFound(err error) error {
 	return err
 }
 
-// Checks if an object ref is in a slice of object refs
+// Kinds the API server serves from more than one group, keyed by the old
+// group. An object read through either group is the same object
+var groupAliases = map[schema.GroupKind]schema.GroupKind{
+	{Group: "extensions", Kind: "DaemonSet"}:         {Group: "apps", Kind: "DaemonSet"},
+	{Group: "extensions", Kind: "Deployment"}:        {Group: "apps", Kind: "Deployment"},
+	{Group: "extensions", Kind: "ReplicaSet"}:        {Group: "apps", Kind: "ReplicaSet"},
+	{Group: "extensions", Kind: "Ingress"}:           {Group: "networking.k8s.io", Kind: "Ingress"},
+	{Group: "extensions", Kind: "NetworkPolicy"}:     {Group: "networking.k8s.io", Kind: "NetworkPolicy"},
+	{Group: "extensions", Kind: "PodSecurityPolicy"}: {Group: "policy", Kind: "PodSecurityPolicy"},
+}
+
+// Returns the group and kind of a ref, with aliased groups normalised
+func refGroupKind(ref corev1.ObjectReference) schema.GroupKind {
+	gk := ref.GroupVersionKind().GroupKind()
+	if alias, ok := groupAliases[gk]; ok {
+		return alias
+	}
+	return gk
+}
+
+// Checks if an object ref is in a slice of object refs. Refs are compared by
+// group, kind, namespace and name so a resource moving to another version of
+// its group, or to a group serving the same kind, is not taken for a new one
 func refInSlice(a corev1.ObjectReference, list []corev1.ObjectReference) bool {
+	gk := refGroupKind(a)
 	for _, b := range list {
-		if b == a {
+		if refGroupKind(b) == gk && b.Namespace == a.Namespace && b.Name == a.Name {
 			return true
 		}
 	}
@@ -0,0 +1,139 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"time"
+
+	stablev1 "github.com/Spazzy757/helm-operator/api/v1"
+	"github.com/Spazzy757/helm-operator/repository"
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// How often a repository index is fetched when no interval is set
+const defaultSyncInterval = 10 * time.Minute
+
+// ChartRepositoryReconciler reconciles a ChartRepository object
+type ChartRepositoryReconciler struct {
+	client.Client
+	Log    logr.Logger
+	Scheme *runtime.Scheme
+	// Indexes of the synced repositories, shared with the chart reconciler
+	Cache *repository.Cache
+	// Directory downloaded archives are kept in
+	CacheDir string
+}
+
+// +kubebuilder:rbac:groups=stable.helm.operator.io,resources=chartrepositories,verbs=get;list;watch
+// +kubebuilder:rbac:groups=stable.helm.operator.io,resources=chartrepositories/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups="",resources=secrets,verbs=get;list;watch
+func (r *ChartRepositoryReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
+	log := r.Log.WithValues("chartrepository", req.NamespacedName)
+	instance := &stablev1.ChartRepository{}
+	if err := r.Get(ctx, req.NamespacedName, instance); err != nil {
+		if ignoreNotFound(err) == nil {
+			r.Cache.Delete(req.Name)
+		}
+		return ctrl.Result{}, ignoreNotFound(err)
+	}
+
+	interval := defaultSyncInterval
+	if instance.Spec.Interval != nil {
+		interval = instance.Spec.Interval.Duration
+	}
+	// Status updates trigger another reconcile, skip the fetch while the
+	// cached index is still fresh for the current spec
+	if _, ok := r.Cache.Get(instance.GetName()); ok &&
+		instance.Status.ObservedGeneration == instance.GetGeneration() &&
+		instance.Status.LastSyncTime != nil {
+		if wait := time.Until(instance.Status.LastSyncTime.Add(interval)); wait > 0 {
+			return ctrl.Result{RequeueAfter: wait}, nil
+		}
+	}
+
+	entry, err := r.sync(instance)
+	instance.Status.ObservedGeneration = instance.GetGeneration()
+	if err != nil {
+		log.Error(err, "unable to sync repository")
+		stablev1.SetCondition(&instance.Status.Conditions, stablev1.Condition{
+			Type:    stablev1.RepositoryReady,
+			Status:  corev1.ConditionFalse,
+			Reason:  failureReason(err),
+			Message: err.Error(),
+		})
+		if err := r.Status().Update(ctx, instance); err != nil {
+			return ctrl.Result{}, err
+		}
+		return ctrl.Result{}, err
+	}
+
+	r.Cache.Set(instance.GetName(), entry)
+	now := metav1.Now()
+	count := 0
+	if entry.Index != nil {
+		for _, versions := range entry.Index.Entries {
+			if len(versions) > 0 {
+				count++
+			}
+		}
+	}
+	instance.Status.LastSyncTime = &now
+	instance.Status.ChartCount = count
+	stablev1.SetCondition(&instance.Status.Conditions, stablev1.Condition{
+		Type:    stablev1.RepositoryReady,
+		Status:  corev1.ConditionTrue,
+		Reason:  "Synced",
+		Message: "Repository is ready to serve charts",
+	})
+	if err := r.Status().Update(ctx, instance); err != nil {
+		return ctrl.Result{}, err
+	}
+	return ctrl.Result{RequeueAfter: interval}, nil
+}
+
+func (r *ChartRepositoryReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&stablev1.ChartRepository{}).
+		Complete(r)
+}
+
+// Fetches the index of the repository with the configured credentials
+func (r *ChartRepositoryReconciler) sync(instance *stablev1.ChartRepository) (*repository.Entry, error) {
+	httpClient, err := repository.NewHTTPClient(instance.Spec.CABundle)
+	if err != nil {
+		return nil, &repository.Error{Reason: repository.ReasonFetchFailed, Err: err}
+	}
+	c := &repository.Client{HTTPClient: httpClient, CacheDir: r.CacheDir}
+	if c.CacheDir == "" {
+		c.CacheDir = "charts"
+	}
+	if err := setCredentials(r.Client, c, instance.Spec.SecretRef, instance.Spec.URL); err != nil {
+		return nil, err
+	}
+	if repository.IsOCI(instance.Spec.URL) {
+		return &repository.Entry{URL: instance.Spec.URL, Client: c}, nil
+	}
+	index, err := c.FetchIndex(instance.Spec.URL)
+	if err != nil {
+		return nil, err
+	}
+	return &repository.Entry{URL: instance.Spec.URL, Client: c, Index: index}, nil
+}
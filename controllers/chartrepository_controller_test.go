@@ -0,0 +1,187 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	stablev1 "github.com/Spazzy757/helm-operator/api/v1"
+	"github.com/Spazzy757/helm-operator/repository"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// Scheme holding the built in types and the types of this operator
+func testScheme() *runtime.Scheme {
+	s := runtime.NewScheme()
+	Expect(clientgoscheme.AddToScheme(s)).To(Succeed())
+	Expect(stablev1.AddToScheme(s)).To(Succeed())
+	return s
+}
+
+var _ = Describe("ChartRepositoryReconciler", func() {
+	var (
+		server   *httptest.Server
+		r        *ChartRepositoryReconciler
+		cacheDir string
+		req      ctrl.Request
+	)
+
+	BeforeEach(func() {
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			if user, pass, ok := req.BasicAuth(); !ok || user != "admin" || pass != "secret" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			fmt.Fprint(w, `apiVersion: v1
+entries:
+  nginx:
+  - name: nginx
+    version: 1.1.0
+    urls:
+    - charts/nginx-1.1.0.tgz
+  redis:
+  - name: redis
+    version: 2.0.0
+    urls:
+    - charts/redis-2.0.0.tgz
+`)
+		}))
+		var err error
+		cacheDir, err = ioutil.TempDir("", "charts-")
+		Expect(err).NotTo(HaveOccurred())
+
+		secret := &corev1.Secret{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Secret"},
+			ObjectMeta: metav1.ObjectMeta{Name: "credentials", Namespace: "default"},
+			Data:       map[string][]byte{"username": []byte("admin"), "password": []byte("secret")},
+		}
+		repo := &stablev1.ChartRepository{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "stable.helm.operator.io/v1", Kind: "ChartRepository"},
+			ObjectMeta: metav1.ObjectMeta{Name: "internal", Generation: 1},
+			Spec: stablev1.ChartRepositorySpec{
+				URL:       server.URL,
+				SecretRef: &corev1.SecretReference{Name: "credentials", Namespace: "default"},
+				Interval:  &metav1.Duration{Duration: time.Minute},
+			},
+		}
+		r = &ChartRepositoryReconciler{
+			Client:   fake.NewFakeClientWithScheme(testScheme(), secret, repo),
+			Log:      ctrl.Log.WithName("test"),
+			Cache:    repository.NewCache(),
+			CacheDir: cacheDir,
+		}
+		req = ctrl.Request{NamespacedName: types.NamespacedName{Name: "internal"}}
+	})
+
+	AfterEach(func() {
+		server.Close()
+		os.RemoveAll(cacheDir)
+	})
+
+	It("should cache the index fetched with the secret credentials", func() {
+		result, err := r.Reconcile(req)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.RequeueAfter).To(Equal(time.Minute))
+
+		entry, ok := r.Cache.Get("internal")
+		Expect(ok).To(BeTrue())
+		Expect(entry.Index.Entries).To(HaveKey("nginx"))
+
+		repo := &stablev1.ChartRepository{}
+		Expect(r.Get(ctx, req.NamespacedName, repo)).To(Succeed())
+		Expect(repo.Status.ChartCount).To(Equal(2))
+		Expect(repo.Status.LastSyncTime).NotTo(BeNil())
+		ready := stablev1.FindCondition(repo.Status.Conditions, stablev1.RepositoryReady)
+		Expect(ready).NotTo(BeNil())
+		Expect(ready.Status).To(Equal(corev1.ConditionTrue))
+	})
+
+	It("should not refetch the index before the interval passes", func() {
+		_, err := r.Reconcile(req)
+		Expect(err).NotTo(HaveOccurred())
+		server.Close()
+
+		result, err := r.Reconcile(req)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.RequeueAfter).To(BeNumerically(">", 0))
+		Expect(result.RequeueAfter).To(BeNumerically("<=", time.Minute))
+	})
+
+	It("should report a failed sync on the Ready condition", func() {
+		secret := &corev1.Secret{}
+		Expect(r.Get(ctx, types.NamespacedName{Name: "credentials", Namespace: "default"}, secret)).To(Succeed())
+		secret.Data["password"] = []byte("wrong")
+		Expect(r.Update(ctx, secret)).To(Succeed())
+
+		_, err := r.Reconcile(req)
+		Expect(err).To(HaveOccurred())
+		_, ok := r.Cache.Get("internal")
+		Expect(ok).To(BeFalse())
+
+		repo := &stablev1.ChartRepository{}
+		Expect(r.Get(ctx, req.NamespacedName, repo)).To(Succeed())
+		ready := stablev1.FindCondition(repo.Status.Conditions, stablev1.RepositoryReady)
+		Expect(ready).NotTo(BeNil())
+		Expect(ready.Status).To(Equal(corev1.ConditionFalse))
+		Expect(ready.Reason).To(Equal(string(repository.ReasonFetchFailed)))
+	})
+
+	It("should drop deleted repositories from the cache", func() {
+		_, err := r.Reconcile(req)
+		Expect(err).NotTo(HaveOccurred())
+		repo := &stablev1.ChartRepository{}
+		Expect(r.Get(ctx, req.NamespacedName, repo)).To(Succeed())
+		Expect(r.Delete(ctx, repo)).To(Succeed())
+
+		_, err = r.Reconcile(req)
+		Expect(err).NotTo(HaveOccurred())
+		_, ok := r.Cache.Get("internal")
+		Expect(ok).To(BeFalse())
+	})
+})
+
+var _ = Describe("setCredentials", func() {
+	It("should read docker config secrets for the registry of the repository", func() {
+		secret := &corev1.Secret{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Secret"},
+			ObjectMeta: metav1.ObjectMeta{Name: "registry", Namespace: "default"},
+			Type:       corev1.SecretTypeDockerConfigJson,
+			Data: map[string][]byte{
+				corev1.DockerConfigJsonKey: []byte(`{"auths": {"registry.example.com": {"username": "robot", "password": "secret"}}}`),
+			},
+		}
+		c := fake.NewFakeClientWithScheme(testScheme(), secret)
+		repo := &repository.Client{}
+		ref := &corev1.SecretReference{Name: "registry", Namespace: "default"}
+		Expect(setCredentials(c, repo, ref, "oci://registry.example.com/charts")).To(Succeed())
+		Expect(repo.Username).To(Equal("robot"))
+		Expect(repo.Password).To(Equal("secret"))
+	})
+})
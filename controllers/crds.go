@@ -0,0 +1,148 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"fmt"
+	"time"
+
+	stablev1 "github.com/Spazzy757/helm-operator/api/v1"
+	apierrs "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	crdGroup = "apiextensions.k8s.io"
+	crdKind  = "CustomResourceDefinition"
+
+	// The CRDs of a chart did not become established in time
+	reasonCRDNotEstablished = "CRDNotEstablished"
+)
+
+var (
+	// How long after their creation the CRDs of a chart have to be served
+	crdEstablishTimeout = 30 * time.Second
+	// How often to check whether the CRDs are served
+	crdPollInterval = 2 * time.Second
+)
+
+// Returns whether a rendered object is a CRD
+func isCRD(u *unstructured.Unstructured) bool {
+	gvk := u.GroupVersionKind()
+	return gvk.Group == crdGroup && gvk.Kind == crdKind
+}
+
+// Splits rendered objects into CRDs and everything else, keeping their order
+func splitCRDs(objects []*unstructured.Unstructured) (crds, rest []*unstructured.Unstructured) {
+	for _, u := range objects {
+		if isCRD(u) {
+			crds = append(crds, u)
+		} else {
+			rest = append(rest, u)
+		}
+	}
+	return crds, rest
+}
+
+// Applies the CRDs of a chart according to its CRD policy and returns whether
+// every one of them is established. Once they are the RESTMapper is refreshed
+// so resources of the new kinds can be applied right after, until then the
+// chart is requeued. CRDs are not owned by the chart
+func (r *ChartReconciler) applyCRDs(instance *stablev1.Chart, crds []*unstructured.Unstructured) (bool, error) {
+	if len(crds) == 0 || instance.Spec.CRDPolicy == stablev1.CRDPolicySkip {
+		return true, nil
+	}
+	if isNamespaced(instance) {
+		return false, &reasonError{
+			reason: reasonNamespaceForbidden,
+			err:    fmt.Errorf("CRDs are cluster-scoped, namespaced charts must set crdPolicy to Skip"),
+		}
+	}
+	for _, crd := range crds {
+		crd.SetNamespace("")
+		if err := setLastApplied(crd); err != nil {
+			return false, err
+		}
+		live := &unstructured.Unstructured{}
+		live.SetGroupVersionKind(crd.GroupVersionKind())
+		if err := r.Get(ctx, types.NamespacedName{Name: crd.GetName()}, live); err != nil {
+			if !apierrs.IsNotFound(err) {
+				return false, err
+			}
+			if err := r.Create(ctx, crd); err != nil {
+				return false, err
+			}
+			r.Log.V(1).Info(fmt.Sprintf("Created CRD: %v", crd.GetName()))
+			continue
+		}
+		if instance.Spec.CRDPolicy != stablev1.CRDPolicyUpdate {
+			continue
+		}
+		patchType, patch, err := threeWayMergePatch(r.Scheme, live, crd)
+		if err != nil {
+			return false, err
+		}
+		if !isEmptyPatch(patch) {
+			if err := r.Patch(ctx, live, client.ConstantPatch(patchType, patch)); err != nil {
+				return false, err
+			}
+			r.Log.V(1).Info(fmt.Sprintf("Updated CRD: %v", crd.GetName()))
+		}
+	}
+
+	for _, crd := range crds {
+		established, err := r.crdEstablished(crd)
+		if err != nil || !established {
+			return false, err
+		}
+	}
+	r.resetMapper()
+	return true, nil
+}
+
+// Returns whether the API server serves the kind defined by a CRD, a CRD
+// that is still not served crdEstablishTimeout after its creation fails
+func (r *ChartReconciler) crdEstablished(crd *unstructured.Unstructured) (bool, error) {
+	live := &unstructured.Unstructured{}
+	live.SetGroupVersionKind(crd.GroupVersionKind())
+	if err := r.Get(ctx, types.NamespacedName{Name: crd.GetName()}, live); err != nil {
+		return false, ignoreNotFound(err)
+	}
+	conditions, _, _ := unstructured.NestedSlice(live.Object, "status", "conditions")
+	for _, c := range conditions {
+		condition, ok := c.(map[string]interface{})
+		if ok && condition["type"] == "Established" && condition["status"] == "True" {
+			return true, nil
+		}
+	}
+	created := live.GetCreationTimestamp()
+	if !created.IsZero() && time.Since(created.Time) > crdEstablishTimeout {
+		return false, &reasonError{
+			reason: reasonCRDNotEstablished,
+			err:    fmt.Errorf("CRD %v not established %v after its creation", crd.GetName(), crdEstablishTimeout),
+		}
+	}
+	return false, nil
+}
+
+// Drops what the RESTMapper discovered so kinds added since are found
+func (r *ChartReconciler) resetMapper() {
+	if m, ok := r.Mapper.(interface{ Reset() }); ok {
+		m.Reset()
+	}
+}
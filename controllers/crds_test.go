@@ -0,0 +1,124 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	stablev1 "github.com/Spazzy757/helm-operator/api/v1"
+	apierrs "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// Builds a CRD manifest, established CRDs carry the condition in their status
+func crd(name, plural string, established bool) *unstructured.Unstructured {
+	u := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "apiextensions.k8s.io/v1beta1",
+		"kind":       "CustomResourceDefinition",
+		"metadata":   map[string]interface{}{"name": name},
+		"spec": map[string]interface{}{
+			"group": "example.com",
+			"names": map[string]interface{}{"kind": "Widget", "plural": plural},
+			"scope": "Namespaced",
+		},
+	}}
+	if established {
+		u.Object["status"] = map[string]interface{}{
+			"conditions": []interface{}{map[string]interface{}{"type": "Established", "status": "True"}},
+		}
+	}
+	return u
+}
+
+var _ = Describe("applyCRDs", func() {
+	var (
+		r        *ChartReconciler
+		instance *stablev1.Chart
+	)
+
+	BeforeEach(func() {
+
+		r = &ChartReconciler{
+			Client: fake.NewFakeClientWithScheme(testScheme(), crd("widgets.example.com", "widgets", true)),
+			Log:    ctrl.Log.WithName("test"),
+			Scheme: testScheme(),
+			Mapper: testMapper(),
+		}
+		instance = &stablev1.Chart{}
+	})
+
+	get := func(name string) *unstructured.Unstructured {
+		u := &unstructured.Unstructured{}
+		u.SetAPIVersion("apiextensions.k8s.io/v1beta1")
+		u.SetKind("CustomResourceDefinition")
+		Expect(r.Get(ctx, types.NamespacedName{Name: name}, u)).To(Succeed())
+		return u
+	}
+
+	It("should split CRDs from other resources", func() {
+		cm := &unstructured.Unstructured{}
+		cm.SetAPIVersion("v1")
+		cm.SetKind("ConfigMap")
+		crds, rest := splitCRDs([]*unstructured.Unstructured{cm, crd("a", "a", false)})
+		Expect(crds).To(HaveLen(1))
+		Expect(rest).To(Equal([]*unstructured.Unstructured{cm}))
+	})
+
+	It("should leave existing CRDs alone by default", func() {
+		Expect(r.applyCRDs(instance, []*unstructured.Unstructured{crd("widgets.example.com", "widgetz", true)})).To(BeTrue())
+		plural, _, _ := unstructured.NestedString(get("widgets.example.com").Object, "spec", "names", "plural")
+		Expect(plural).To(Equal("widgets"))
+	})
+
+	It("should update existing CRDs with the Update policy", func() {
+		instance.Spec.CRDPolicy = stablev1.CRDPolicyUpdate
+		Expect(r.applyCRDs(instance, []*unstructured.Unstructured{crd("widgets.example.com", "widgetz", true)})).To(BeTrue())
+		plural, _, _ := unstructured.NestedString(get("widgets.example.com").Object, "spec", "names", "plural")
+		Expect(plural).To(Equal("widgetz"))
+	})
+
+	It("should not touch CRDs with the Skip policy", func() {
+		instance.Spec.CRDPolicy = stablev1.CRDPolicySkip
+		Expect(r.applyCRDs(instance, []*unstructured.Unstructured{crd("gadgets.example.com", "gadgets", true)})).To(BeTrue())
+		u := &unstructured.Unstructured{}
+		u.SetAPIVersion("apiextensions.k8s.io/v1beta1")
+		u.SetKind("CustomResourceDefinition")
+		err := r.Get(ctx, types.NamespacedName{Name: "gadgets.example.com"}, u)
+		Expect(apierrs.IsNotFound(err)).To(BeTrue())
+	})
+
+	It("should create missing CRDs and wait for them to be established", func() {
+		established, err := r.applyCRDs(instance, []*unstructured.Unstructured{crd("gadgets.example.com", "gadgets", false)})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(established).To(BeFalse())
+		get("gadgets.example.com")
+	})
+
+	It("should fail when CRDs are not established in time", func() {
+		gadgets := crd("gadgets.example.com", "gadgets", false)
+		gadgets.SetCreationTimestamp(metav1.NewTime(time.Now().Add(-2 * crdEstablishTimeout)))
+		Expect(r.Create(ctx, gadgets)).To(Succeed())
+		_, err := r.applyCRDs(instance, []*unstructured.Unstructured{crd("gadgets.example.com", "gadgets", false)})
+		Expect(failureReason(err)).To(Equal(reasonCRDNotEstablished))
+	})
+})
@@ -0,0 +1,46 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"github.com/Spazzy757/helm-operator/repository"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Loads the credentials of a repository from the referenced secret onto the
+// client, docker config secrets are looked up by the registry of the repository
+func setCredentials(c client.Client, repo *repository.Client, ref *corev1.SecretReference, repoURL string) error {
+	if ref == nil {
+		return nil
+	}
+	secret := &corev1.Secret{}
+	if err := c.Get(ctx, types.NamespacedName{Namespace: ref.Namespace, Name: ref.Name}, secret); err != nil {
+		return err
+	}
+	if secret.Type == corev1.SecretTypeDockerConfigJson {
+		username, password, err := repository.CredentialsFromDockerConfig(secret.Data[corev1.DockerConfigJsonKey], repoURL)
+		if err != nil {
+			return &repository.Error{Reason: repository.ReasonFetchFailed, Err: err}
+		}
+		repo.Username, repo.Password = username, password
+		return nil
+	}
+	repo.Username = string(secret.Data["username"])
+	repo.Password = string(secret.Data["password"])
+	return nil
+}
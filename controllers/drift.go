@@ -0,0 +1,268 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+
+	stablev1 "github.com/Spazzy757/helm-operator/api/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+const (
+	// Resources of the chart were changed and left as they are
+	reasonDriftDetected = "DriftDetected"
+	// Resources of the chart were changed and the chart was applied again
+	reasonDriftCorrected = "DriftCorrected"
+	// Resources of the chart match it
+	reasonNoDrift = "NoDrift"
+	// A drift ignore rule is not a JSON pointer
+	reasonInvalidDriftIgnore = "InvalidDriftIgnore"
+)
+
+// Returns the drift detection mode of the instance, disabled by default
+func driftMode(instance *stablev1.Chart) stablev1.DriftDetectionMode {
+	if instance.Spec.DriftDetection == "" {
+		return stablev1.DriftDetectionDisabled
+	}
+	return instance.Spec.DriftDetection
+}
+
+// Returns the JSON pointers of the fields of a resource left out of drift
+// detection
+func ignoredPaths(instance *stablev1.Chart, u *unstructured.Unstructured) []string {
+	var paths []string
+	for _, rule := range instance.Spec.DriftIgnore {
+		if t := rule.Target; t != nil {
+			if (t.Kind != "" && t.Kind != u.GetKind()) ||
+				(t.Name != "" && t.Name != u.GetName()) ||
+				(t.Namespace != "" && t.Namespace != u.GetNamespace()) {
+				continue
+			}
+		}
+		paths = append(paths, rule.Paths...)
+	}
+	return paths
+}
+
+// Copies the ignored fields of the live resource into the rendered one, so
+// applying the chart leaves them as they are
+func keepIgnoredFields(paths []string, live, rendered *unstructured.Unstructured) error {
+	for _, path := range paths {
+		tokens, err := pointerTokens(path)
+		if err != nil {
+			return err
+		}
+		if value, ok := pointerGet(live.Object, tokens); ok {
+			pointerSet(rendered.Object, tokens, runtime.DeepCopyJSONValue(value))
+		}
+	}
+	return nil
+}
+
+// Splits a JSON pointer into its unescaped reference tokens
+func pointerTokens(pointer string) ([]string, error) {
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, &reasonError{
+			reason: reasonInvalidDriftIgnore,
+			err:    fmt.Errorf("invalid JSON pointer %q", pointer),
+		}
+	}
+	tokens := strings.Split(pointer[1:], "/")
+	for i, t := range tokens {
+		tokens[i] = strings.Replace(strings.Replace(t, "~1", "/", -1), "~0", "~", -1)
+	}
+	return tokens, nil
+}
+
+// Returns the value a JSON pointer refers to
+func pointerGet(obj interface{}, tokens []string) (interface{}, bool) {
+	for _, t := range tokens {
+		switch v := obj.(type) {
+		case map[string]interface{}:
+			next, ok := v[t]
+			if !ok {
+				return nil, false
+			}
+			obj = next
+		case []interface{}:
+			i, err := strconv.Atoi(t)
+			if err != nil || i < 0 || i >= len(v) {
+				return nil, false
+			}
+			obj = v[i]
+		default:
+			return nil, false
+		}
+	}
+	return obj, true
+}
+
+// Sets the value a JSON pointer refers to, missing objects on the way are
+// created while missing list items are left alone
+func pointerSet(obj map[string]interface{}, tokens []string, value interface{}) {
+	var parent interface{} = obj
+	for i, t := range tokens {
+		last := i == len(tokens)-1
+		switch v := parent.(type) {
+		case map[string]interface{}:
+			if last {
+				v[t] = value
+				return
+			}
+			next, ok := v[t]
+			if !ok {
+				next = map[string]interface{}{}
+				v[t] = next
+			}
+			parent = next
+		case []interface{}:
+			index, err := strconv.Atoi(t)
+			if err != nil || index < 0 || index >= len(v) {
+				return
+			}
+			if last {
+				v[index] = value
+				return
+			}
+			parent = v[index]
+		default:
+			return
+		}
+	}
+}
+
+// Returns the drift of a resource, the patch reverting it leaves out the
+// last applied annotation and the values of Secrets
+func drifted(resource corev1.ObjectReference, reason stablev1.DriftReason, patch []byte) stablev1.DriftedResource {
+	return stablev1.DriftedResource{
+		APIVersion: resource.APIVersion,
+		Kind:       resource.Kind,
+		Name:       resource.Name,
+		Namespace:  resource.Namespace,
+		Reason:     reason,
+		Patch:      string(redactPatch(resource.GroupVersionKind(), patch)),
+	}
+}
+
+// Records the drift found while applying the chart in the Drifted condition,
+// drift that was left in place is listed in the status
+func reportDrift(instance *stablev1.Chart, drift []stablev1.DriftedResource) {
+	mode := driftMode(instance)
+	instance.Status.Drift = nil
+	if mode == stablev1.DriftDetectionDisabled {
+		stablev1.RemoveCondition(&instance.Status.Conditions, stablev1.ChartDrifted)
+		return
+	}
+	if len(drift) == 0 {
+		stablev1.SetCondition(&instance.Status.Conditions, stablev1.Condition{
+			Type:    stablev1.ChartDrifted,
+			Status:  corev1.ConditionFalse,
+			Reason:  reasonNoDrift,
+			Message: "Resources match the chart",
+		})
+		return
+	}
+	var names []string
+	for _, d := range drift {
+		names = append(names, fmt.Sprintf("%v %v %v", d.Kind, d.Name, strings.ToLower(string(d.Reason))))
+	}
+	if mode == stablev1.DriftDetectionWarn {
+		instance.Status.Drift = drift
+		stablev1.SetCondition(&instance.Status.Conditions, stablev1.Condition{
+			Type:    stablev1.ChartDrifted,
+			Status:  corev1.ConditionTrue,
+			Reason:  reasonDriftDetected,
+			Message: fmt.Sprintf("Resources drifted from the chart: %v", strings.Join(names, ", ")),
+		})
+		return
+	}
+	stablev1.SetCondition(&instance.Status.Conditions, stablev1.Condition{
+		Type:    stablev1.ChartDrifted,
+		Status:  corev1.ConditionFalse,
+		Reason:  reasonDriftCorrected,
+		Message: fmt.Sprintf("Corrected drift: %v", strings.Join(names, ", ")),
+	})
+}
+
+// Changes to resources that are worth reconciling their chart for, updates of
+// the status alone are left out
+var driftPredicate = predicate.Funcs{
+	CreateFunc: func(event.CreateEvent) bool { return false },
+	UpdateFunc: func(e event.UpdateEvent) bool {
+		if e.MetaNew.GetGeneration() == 0 {
+			// kinds without a spec, such as ConfigMaps
+			return e.MetaNew.GetResourceVersion() != e.MetaOld.GetResourceVersion()
+		}
+		return e.MetaNew.GetGeneration() != e.MetaOld.GetGeneration() ||
+			!reflect.DeepEqual(e.MetaNew.GetLabels(), e.MetaOld.GetLabels()) ||
+			!reflect.DeepEqual(e.MetaNew.GetAnnotations(), e.MetaOld.GetAnnotations())
+	},
+	GenericFunc: func(event.GenericEvent) bool { return false },
+}
+
+// resourceWatches are the watches of the controller on the kinds of chart
+// resources
+type resourceWatches struct {
+	controller controller.Controller
+	mu         sync.Mutex
+	watched    map[schema.GroupVersionKind]bool
+}
+
+// Watches the kinds of the resources of the chart, so the charts they belong
+// to are reconciled when they are changed or deleted
+func (r *ChartReconciler) watchResources(resources []corev1.ObjectReference) {
+	w := r.watches
+	if w == nil {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.watched == nil {
+		w.watched = map[schema.GroupVersionKind]bool{}
+	}
+	for _, resource := range resources {
+		gvk := resource.GroupVersionKind()
+		if w.watched[gvk] {
+			continue
+		}
+		u := &unstructured.Unstructured{}
+		u.SetGroupVersionKind(gvk)
+		src := &source.Kind{Type: u}
+		for _, owner := range []runtime.Object{&stablev1.Chart{}, &stablev1.NamespacedChart{}} {
+			err := w.controller.Watch(src, &handler.EnqueueRequestForOwner{
+				OwnerType:    owner,
+				IsController: true,
+			}, driftPredicate)
+			if err != nil {
+				r.Log.Error(err, "unable to watch resources", "kind", gvk)
+			}
+		}
+		w.watched[gvk] = true
+	}
+}
@@ -0,0 +1,122 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	stablev1 "github.com/Spazzy757/helm-operator/api/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+)
+
+var _ = Describe("drift detection", func() {
+	It("should keep the ignored fields of live resources", func() {
+		current := live(`
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+spec:
+  replicas: 5
+  template:
+    metadata:
+      annotations:
+        example.com/restarted: "true"
+    spec:
+      containers:
+      - name: web
+        image: web:2
+`)
+		rendered := live(`
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+spec:
+  replicas: 2
+  template:
+    spec:
+      containers:
+      - name: web
+        image: web:1
+`)
+		paths := []string{"/spec/replicas", "/spec/template/metadata/annotations/example.com~1restarted", "/spec/template/spec/containers/0/image", "/spec/missing"}
+		Expect(keepIgnoredFields(paths, current, rendered)).To(Succeed())
+		Expect(rendered.Object["spec"]).To(Equal(current.Object["spec"]))
+
+		Expect(keepIgnoredFields([]string{"spec/replicas"}, current, rendered)).NotTo(Succeed())
+	})
+
+	It("should only apply ignore rules to their targets", func() {
+		instance := &stablev1.Chart{Spec: stablev1.ChartSpec{DriftIgnore: []stablev1.DriftIgnoreRule{
+			{Paths: []string{"/metadata/labels"}},
+			{Paths: []string{"/spec/replicas"}, Target: &stablev1.DriftTarget{Kind: "Deployment"}},
+			{Paths: []string{"/data"}, Target: &stablev1.DriftTarget{Kind: "ConfigMap", Name: "other"}},
+		}}}
+		u := &unstructured.Unstructured{}
+		u.SetKind("Deployment")
+		u.SetName("web")
+		Expect(ignoredPaths(instance, u)).To(Equal([]string{"/metadata/labels", "/spec/replicas"}))
+		u.SetKind("ConfigMap")
+		Expect(ignoredPaths(instance, u)).To(Equal([]string{"/metadata/labels"}))
+	})
+
+	It("should report drift by mode", func() {
+		instance := &stablev1.Chart{}
+		drift := []stablev1.DriftedResource{drifted(corev1.ObjectReference{Kind: "ConfigMap", Name: "foo"}, stablev1.DriftDeleted, nil)}
+		condition := func() *stablev1.Condition {
+			return stablev1.FindCondition(instance.Status.Conditions, stablev1.ChartDrifted)
+		}
+
+		reportDrift(instance, drift)
+		Expect(condition()).To(BeNil())
+
+		instance.Spec.DriftDetection = stablev1.DriftDetectionWarn
+		reportDrift(instance, drift)
+		Expect(condition().Status).To(Equal(corev1.ConditionTrue))
+		Expect(condition().Message).To(ContainSubstring("ConfigMap foo deleted"))
+		Expect(instance.Status.Drift).To(Equal(drift))
+
+		instance.Spec.DriftDetection = stablev1.DriftDetectionEnabled
+		reportDrift(instance, drift)
+		Expect(condition().Reason).To(Equal(reasonDriftCorrected))
+		Expect(instance.Status.Drift).To(BeEmpty())
+
+		reportDrift(instance, nil)
+		Expect(condition().Reason).To(Equal(reasonNoDrift))
+	})
+
+	It("should redact the patches reverting drift", func() {
+		secret := corev1.ObjectReference{APIVersion: "v1", Kind: "Secret", Name: "creds"}
+		patch := []byte(`{"metadata":{"annotations":{"` + lastAppliedAnnotation + `":"{}"}},"data":{"password":"aHVudGVyMg=="}}`)
+		Expect(drifted(secret, stablev1.DriftModified, patch).Patch).To(Equal(`{"data":{"password":"REDACTED"}}`))
+		Expect(drifted(secret, stablev1.DriftDeleted, nil).Patch).To(BeEmpty())
+	})
+
+	It("should leave out status updates", func() {
+		update := func(oldMeta, newMeta metav1.ObjectMeta) bool {
+			return driftPredicate.Update(event.UpdateEvent{MetaOld: &oldMeta, MetaNew: &newMeta})
+		}
+		Expect(update(metav1.ObjectMeta{Generation: 1, ResourceVersion: "1"}, metav1.ObjectMeta{Generation: 1, ResourceVersion: "2"})).To(BeFalse())
+		Expect(update(metav1.ObjectMeta{Generation: 1}, metav1.ObjectMeta{Generation: 2})).To(BeTrue())
+		Expect(update(metav1.ObjectMeta{Generation: 1}, metav1.ObjectMeta{Generation: 1, Labels: map[string]string{"a": "b"}})).To(BeTrue())
+		Expect(update(metav1.ObjectMeta{ResourceVersion: "1"}, metav1.ObjectMeta{ResourceVersion: "2"})).To(BeTrue())
+	})
+})
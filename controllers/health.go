@@ -0,0 +1,277 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	stablev1 "github.com/Spazzy757/helm-operator/api/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrs "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const (
+	// A resource of the chart failed
+	reasonUnhealthy = "Unhealthy"
+	// Resources of the chart did not become healthy in time
+	reasonTimeout = "Timeout"
+	// Every resource of the chart is healthy
+	reasonHealthy = "Healthy"
+)
+
+var (
+	// How long to wait for hooks and resources when the chart sets no timeout
+	defaultTimeout = 5 * time.Minute
+	// How often the health of progressing resources is checked
+	healthPollInterval = 5 * time.Second
+)
+
+// HealthCheck assesses the health of a live resource, along with a message
+// saying why it is not healthy
+type HealthCheck func(live *unstructured.Unstructured) (stablev1.HealthStatus, string)
+
+// Health checks of the kinds a chart waits for, resources of other kinds are
+// healthy once applied
+var defaultHealthChecks = map[schema.GroupKind]HealthCheck{
+	{Group: "apps", Kind: "Deployment"}:       deploymentHealth,
+	{Group: "extensions", Kind: "Deployment"}: deploymentHealth,
+	{Group: "apps", Kind: "StatefulSet"}:      statefulSetHealth,
+	{Group: "apps", Kind: "DaemonSet"}:        daemonSetHealth,
+	{Group: "extensions", Kind: "DaemonSet"}:  daemonSetHealth,
+	{Group: "batch", Kind: "Job"}:             jobHealth,
+	{Kind: "PersistentVolumeClaim"}:           pvcHealth,
+	{Kind: "Service"}:                         serviceHealth,
+}
+
+// Returns how long to wait for hooks and resources of the instance
+func timeout(instance *stablev1.Chart) time.Duration {
+	if instance.Spec.Timeout != nil {
+		return instance.Spec.Timeout.Duration
+	}
+	return defaultTimeout
+}
+
+// Returns the health check of a kind, checks of the reconciler take
+// precedence over the built-in ones
+func (r *ChartReconciler) healthCheck(gk schema.GroupKind) HealthCheck {
+	if check, ok := r.HealthChecks[gk]; ok {
+		return check
+	}
+	return defaultHealthChecks[gk]
+}
+
+// Checks the health of every resource with a health check once, recording
+// the health of each one in the status of the instance. healthy is false
+// while resources are still progressing, they fail once the health deadline
+// of the instance passed
+func (r *ChartReconciler) checkHealth(instance *stablev1.Chart, resources []corev1.ObjectReference) (bool, error) {
+	var health []stablev1.ResourceHealth
+	for _, resource := range resources {
+		check := r.healthCheck(resource.GroupVersionKind().GroupKind())
+		if check == nil {
+			continue
+		}
+		status, message, err := r.assessHealth(resource, check)
+		if err != nil {
+			return false, err
+		}
+		health = append(health, stablev1.ResourceHealth{
+			APIVersion: resource.APIVersion,
+			Kind:       resource.Kind,
+			Name:       resource.Name,
+			Namespace:  resource.Namespace,
+			Health:     status,
+			Message:    message,
+		})
+	}
+	instance.Status.ResourceHealth = health
+
+	if unhealthy := describeHealth(health, stablev1.HealthUnhealthy); unhealthy != "" {
+		instance.Status.HealthDeadline = nil
+		return false, &reasonError{
+			reason: reasonUnhealthy,
+			err:    fmt.Errorf("resources failed: %v", unhealthy),
+		}
+	}
+	progressing := describeHealth(health, stablev1.HealthProgressing)
+	if progressing == "" {
+		instance.Status.HealthDeadline = nil
+		return true, nil
+	}
+	if instance.Status.HealthDeadline == nil {
+		deadline := metav1.NewTime(time.Now().Add(timeout(instance)))
+		instance.Status.HealthDeadline = &deadline
+	}
+	if time.Now().Before(instance.Status.HealthDeadline.Time) {
+		return false, nil
+	}
+	instance.Status.HealthDeadline = nil
+	return false, &reasonError{
+		reason: reasonTimeout,
+		err:    fmt.Errorf("resources not ready after %v: %v", timeout(instance), progressing),
+	}
+}
+
+// Describes the resources of the given health
+func describeHealth(health []stablev1.ResourceHealth, status stablev1.HealthStatus) string {
+	var descriptions []string
+	for _, h := range health {
+		if h.Health == status {
+			descriptions = append(descriptions, fmt.Sprintf("%v %v: %v", h.Kind, h.Name, h.Message))
+		}
+	}
+	return strings.Join(descriptions, ", ")
+}
+
+// Fetches a resource and runs its health check, missing resources are still
+// progressing
+func (r *ChartReconciler) assessHealth(resource corev1.ObjectReference, check HealthCheck) (stablev1.HealthStatus, string, error) {
+	live := &unstructured.Unstructured{}
+	live.SetGroupVersionKind(resource.GroupVersionKind())
+	if err := r.Get(ctx, types.NamespacedName{Namespace: resource.Namespace, Name: resource.Name}, live); err != nil {
+		if apierrs.IsNotFound(err) {
+			return stablev1.HealthProgressing, "not found", nil
+		}
+		return "", "", err
+	}
+	status, message := check(live)
+	return status, message, nil
+}
+
+// Returns whether the controller of a resource saw its latest spec
+func observedLatest(live *unstructured.Unstructured) bool {
+	observed, found, _ := unstructured.NestedInt64(live.Object, "status", "observedGeneration")
+	return found && observed >= live.GetGeneration()
+}
+
+// Returns a numeric field, or the default when it is not set
+func nestedInt64(live *unstructured.Unstructured, def int64, fields ...string) int64 {
+	if v, found, _ := unstructured.NestedInt64(live.Object, fields...); found {
+		return v
+	}
+	return def
+}
+
+// Returns the condition of the given type from the status of a resource
+func statusCondition(live *unstructured.Unstructured, conditionType string) map[string]interface{} {
+	conditions, _, _ := unstructured.NestedSlice(live.Object, "status", "conditions")
+	for _, c := range conditions {
+		if condition, ok := c.(map[string]interface{}); ok && condition["type"] == conditionType {
+			return condition
+		}
+	}
+	return nil
+}
+
+// Deployments are healthy once every replica is updated and available, and
+// fail when they exceed their progress deadline
+func deploymentHealth(live *unstructured.Unstructured) (stablev1.HealthStatus, string) {
+	if !observedLatest(live) {
+		return stablev1.HealthProgressing, "waiting for the rollout to start"
+	}
+	if c := statusCondition(live, "Progressing"); c != nil && c["reason"] == "ProgressDeadlineExceeded" {
+		message, _ := c["message"].(string)
+		return stablev1.HealthUnhealthy, message
+	}
+	replicas := nestedInt64(live, 1, "spec", "replicas")
+	if updated := nestedInt64(live, 0, "status", "updatedReplicas"); updated < replicas {
+		return stablev1.HealthProgressing, fmt.Sprintf("%d of %d replicas updated", updated, replicas)
+	}
+	if available := nestedInt64(live, 0, "status", "availableReplicas"); available < replicas {
+		return stablev1.HealthProgressing, fmt.Sprintf("%d of %d replicas available", available, replicas)
+	}
+	return stablev1.HealthHealthy, ""
+}
+
+// StatefulSets are healthy once the replicas outside the partition are
+// updated and every replica is ready
+func statefulSetHealth(live *unstructured.Unstructured) (stablev1.HealthStatus, string) {
+	if !observedLatest(live) {
+		return stablev1.HealthProgressing, "waiting for the rollout to start"
+	}
+	replicas := nestedInt64(live, 1, "spec", "replicas")
+	strategy, _, _ := unstructured.NestedString(live.Object, "spec", "updateStrategy", "type")
+	if strategy != "OnDelete" {
+		expected := replicas - nestedInt64(live, 0, "spec", "updateStrategy", "rollingUpdate", "partition")
+		if updated := nestedInt64(live, 0, "status", "updatedReplicas"); updated < expected {
+			return stablev1.HealthProgressing, fmt.Sprintf("%d of %d replicas updated", updated, expected)
+		}
+	}
+	if ready := nestedInt64(live, 0, "status", "readyReplicas"); ready < replicas {
+		return stablev1.HealthProgressing, fmt.Sprintf("%d of %d replicas ready", ready, replicas)
+	}
+	return stablev1.HealthHealthy, ""
+}
+
+// DaemonSets are healthy once every scheduled pod is updated and available
+func daemonSetHealth(live *unstructured.Unstructured) (stablev1.HealthStatus, string) {
+	if !observedLatest(live) {
+		return stablev1.HealthProgressing, "waiting for the rollout to start"
+	}
+	desired := nestedInt64(live, 0, "status", "desiredNumberScheduled")
+	strategy, _, _ := unstructured.NestedString(live.Object, "spec", "updateStrategy", "type")
+	if strategy != "OnDelete" {
+		if updated := nestedInt64(live, 0, "status", "updatedNumberScheduled"); updated < desired {
+			return stablev1.HealthProgressing, fmt.Sprintf("%d of %d pods updated", updated, desired)
+		}
+	}
+	if available := nestedInt64(live, 0, "status", "numberAvailable"); available < desired {
+		return stablev1.HealthProgressing, fmt.Sprintf("%d of %d pods available", available, desired)
+	}
+	return stablev1.HealthHealthy, ""
+}
+
+// Jobs are healthy once they complete
+func jobHealth(live *unstructured.Unstructured) (stablev1.HealthStatus, string) {
+	switch phase, message := hookPhase(live); phase {
+	case stablev1.HookPhaseSucceeded:
+		return stablev1.HealthHealthy, ""
+	case stablev1.HookPhaseFailed:
+		return stablev1.HealthUnhealthy, message
+	}
+	return stablev1.HealthProgressing, "not completed"
+}
+
+// PersistentVolumeClaims are healthy once bound
+func pvcHealth(live *unstructured.Unstructured) (stablev1.HealthStatus, string) {
+	switch phase, _, _ := unstructured.NestedString(live.Object, "status", "phase"); phase {
+	case "Bound":
+		return stablev1.HealthHealthy, ""
+	case "Lost":
+		return stablev1.HealthUnhealthy, "volume lost"
+	}
+	return stablev1.HealthProgressing, "not bound"
+}
+
+// Services are healthy once applied, load balancers once they have an
+// ingress point
+func serviceHealth(live *unstructured.Unstructured) (stablev1.HealthStatus, string) {
+	serviceType, _, _ := unstructured.NestedString(live.Object, "spec", "type")
+	if serviceType != "LoadBalancer" {
+		return stablev1.HealthHealthy, ""
+	}
+	ingress, _, _ := unstructured.NestedSlice(live.Object, "status", "loadBalancer", "ingress")
+	if len(ingress) == 0 {
+		return stablev1.HealthProgressing, "waiting for a load balancer"
+	}
+	return stablev1.HealthHealthy, ""
+}
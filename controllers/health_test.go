@@ -0,0 +1,174 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	stablev1 "github.com/Spazzy757/helm-operator/api/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/yaml"
+)
+
+// Decodes a live resource from YAML the way the client does, with integer
+// numbers
+func live(manifest string) *unstructured.Unstructured {
+	b, err := yaml.YAMLToJSON([]byte(manifest))
+	Expect(err).NotTo(HaveOccurred())
+	u := &unstructured.Unstructured{}
+	Expect(u.UnmarshalJSON(b)).To(Succeed())
+	return u
+}
+
+// Returns only the health of a resource
+func health(check HealthCheck, u *unstructured.Unstructured) stablev1.HealthStatus {
+	status, _ := check(u)
+	return status
+}
+
+var _ = Describe("health checks", func() {
+	It("should wait for Deployments to roll out", func() {
+		d := live(`
+apiVersion: apps/v1
+kind: Deployment
+metadata: {name: web, namespace: apps, generation: 2}
+spec: {replicas: 3}
+status: {observedGeneration: 1}
+`)
+		Expect(health(deploymentHealth, d)).To(Equal(stablev1.HealthProgressing))
+
+		Expect(unstructured.SetNestedMap(d.Object, map[string]interface{}{
+			"observedGeneration": int64(2), "updatedReplicas": int64(3), "availableReplicas": int64(2),
+		}, "status")).To(Succeed())
+		status, message := deploymentHealth(d)
+		Expect(status).To(Equal(stablev1.HealthProgressing))
+		Expect(message).To(Equal("2 of 3 replicas available"))
+
+		Expect(unstructured.SetNestedField(d.Object, int64(3), "status", "availableReplicas")).To(Succeed())
+		Expect(health(deploymentHealth, d)).To(Equal(stablev1.HealthHealthy))
+
+		Expect(unstructured.SetNestedSlice(d.Object, []interface{}{map[string]interface{}{
+			"type": "Progressing", "status": "False", "reason": "ProgressDeadlineExceeded",
+		}}, "status", "conditions")).To(Succeed())
+		Expect(health(deploymentHealth, d)).To(Equal(stablev1.HealthUnhealthy))
+	})
+
+	It("should only count StatefulSet replicas outside the partition as updated", func() {
+		s := live(`
+apiVersion: apps/v1
+kind: StatefulSet
+metadata: {name: db, generation: 1}
+spec:
+  replicas: 3
+  updateStrategy: {type: RollingUpdate, rollingUpdate: {partition: 2}}
+status: {observedGeneration: 1, updatedReplicas: 1, readyReplicas: 3}
+`)
+		Expect(health(statefulSetHealth, s)).To(Equal(stablev1.HealthHealthy))
+	})
+
+	It("should wait for DaemonSets, PVCs and load balancers", func() {
+		Expect(health(daemonSetHealth, live(`
+kind: DaemonSet
+metadata: {generation: 1}
+status: {observedGeneration: 1, desiredNumberScheduled: 2, updatedNumberScheduled: 2, numberAvailable: 1}
+`))).To(Equal(stablev1.HealthProgressing))
+		Expect(health(pvcHealth, live(`{kind: PersistentVolumeClaim, status: {phase: Bound}}`))).To(Equal(stablev1.HealthHealthy))
+		Expect(health(pvcHealth, live(`{kind: PersistentVolumeClaim, status: {phase: Lost}}`))).To(Equal(stablev1.HealthUnhealthy))
+		Expect(health(serviceHealth, live(`{kind: Service, spec: {type: ClusterIP}}`))).To(Equal(stablev1.HealthHealthy))
+		Expect(health(serviceHealth, live(`{kind: Service, spec: {type: LoadBalancer}}`))).To(Equal(stablev1.HealthProgressing))
+	})
+
+	Context("checkHealth", func() {
+		var (
+			r        *ChartReconciler
+			instance *stablev1.Chart
+		)
+
+		BeforeEach(func() {
+			instance = &stablev1.Chart{
+				Spec: stablev1.ChartSpec{Timeout: &metav1.Duration{Duration: time.Minute}},
+			}
+			job := live(`
+apiVersion: batch/v1
+kind: Job
+metadata: {name: migrate, namespace: apps}
+status: {conditions: [{type: Complete, status: "True"}]}
+`)
+			claim := live(`
+apiVersion: v1
+kind: PersistentVolumeClaim
+metadata: {name: data, namespace: apps}
+status: {phase: Pending}
+`)
+			r = &ChartReconciler{
+				Client: fake.NewFakeClientWithScheme(testScheme(), job, claim),
+				Log:    ctrl.Log.WithName("test"),
+				Scheme: testScheme(),
+			}
+		})
+
+		ref := func(apiVersion, kind, name string) corev1.ObjectReference {
+			return corev1.ObjectReference{APIVersion: apiVersion, Kind: kind, Name: name, Namespace: "apps"}
+		}
+
+		It("should succeed once every resource is healthy", func() {
+			Expect(r.checkHealth(instance, []corev1.ObjectReference{
+				ref("batch/v1", "Job", "migrate"),
+				ref("v1", "ConfigMap", "unchecked"),
+			})).To(BeTrue())
+			Expect(instance.Status.ResourceHealth).To(Equal([]stablev1.ResourceHealth{{
+				APIVersion: "batch/v1", Kind: "Job", Name: "migrate", Namespace: "apps", Health: stablev1.HealthHealthy,
+			}}))
+		})
+
+		It("should fail once the deadline passes", func() {
+			resources := []corev1.ObjectReference{
+				ref("batch/v1", "Job", "migrate"),
+				ref("v1", "PersistentVolumeClaim", "data"),
+			}
+			Expect(r.checkHealth(instance, resources)).To(BeFalse())
+			Expect(instance.Status.HealthDeadline).NotTo(BeNil())
+			Expect(instance.Status.HealthDeadline.Time).To(BeTemporally("~", time.Now().Add(time.Minute), time.Second))
+			Expect(describeHealth(instance.Status.ResourceHealth, stablev1.HealthProgressing)).To(Equal("PersistentVolumeClaim data: not bound"))
+
+			passed := metav1.NewTime(time.Now().Add(-time.Second))
+			instance.Status.HealthDeadline = &passed
+			healthy, err := r.checkHealth(instance, resources)
+			Expect(healthy).To(BeFalse())
+			Expect(failureReason(err)).To(Equal(reasonTimeout))
+			Expect(err.Error()).To(ContainSubstring("PersistentVolumeClaim data: not bound"))
+			Expect(instance.Status.ResourceHealth).To(HaveLen(2))
+			Expect(instance.Status.ResourceHealth[1].Health).To(Equal(stablev1.HealthProgressing))
+		})
+
+		It("should prefer health checks of the reconciler", func() {
+			r.HealthChecks = map[schema.GroupKind]HealthCheck{
+				{Kind: "PersistentVolumeClaim"}: func(*unstructured.Unstructured) (stablev1.HealthStatus, string) {
+					return stablev1.HealthHealthy, ""
+				},
+			}
+			Expect(r.checkHealth(instance, []corev1.ObjectReference{ref("v1", "PersistentVolumeClaim", "data")})).To(BeTrue())
+		})
+	})
+})
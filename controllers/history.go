@@ -0,0 +1,309 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+
+	stablev1 "github.com/Spazzy757/helm-operator/api/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrs "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+const (
+	// Labels of the Secrets holding the manifests of release revisions
+	chartLabel    = "stable.helm.operator.io/chart"
+	revisionLabel = "stable.helm.operator.io/revision"
+	manifestKey   = "manifest"
+
+	defaultMaxHistory = 10
+
+	// Separates the error of a failed attempt from the description of a revision
+	failedDescription = " failed: "
+
+	// The revision to roll back to is not in the history
+	reasonRevisionNotFound = "RevisionNotFound"
+	// A previous revision is deployed instead of the chart
+	reasonRolledBack = "RolledBack"
+)
+
+// release is a chart rendered with its values, or the stored manifest of a
+// previous revision
+type release struct {
+	manifest []byte
+	// Digest of the chart and values, see releaseDigest
+	digest       string
+	valuesDigest string
+	// Revision of the chart, its version or git commit
+	chartRevision string
+	// Name and version of the chart in its Chart.yaml
+	chart        string
+	chartVersion string
+	// Release revision the manifest was read from, 0 for rendered charts
+	rollbackOf int64
+}
+
+// Returns the release to deploy, a stored revision when the chart is pinned
+// to one or when the rendered release was rolled back
+func (r *ChartReconciler) targetRelease(instance *stablev1.Chart, rendered *release) (*release, error) {
+	if rollback := instance.Spec.Rollback; rollback != nil && rollback.Revision != 0 {
+		return r.storedRelease(instance, rollback.Revision)
+	}
+	if rendered.digest == instance.Status.RolledBackDigest && instance.Status.CurrentRevision != 0 {
+		return r.storedRelease(instance, instance.Status.CurrentRevision)
+	}
+	return rendered, nil
+}
+
+// Returns the name of the Secret holding the manifest of a revision
+func revisionSecretName(instance *stablev1.Chart, revision int64) string {
+	if isNamespaced(instance) {
+		// kept apart from a Chart of the same name deploying to the namespace
+		return fmt.Sprintf("helm-operator.namespaced.%s.v%d", instance.GetName(), revision)
+	}
+	return fmt.Sprintf("helm-operator.%s.v%d", instance.GetName(), revision)
+}
+
+// Returns the record of a revision from the history
+func findRevision(instance *stablev1.Chart, revision int64) *stablev1.ReleaseRecord {
+	for i := range instance.Status.History {
+		if instance.Status.History[i].Revision == revision {
+			return &instance.Status.History[i]
+		}
+	}
+	return nil
+}
+
+// Returns the latest record of the history
+func latestRevision(instance *stablev1.Chart) *stablev1.ReleaseRecord {
+	if n := len(instance.Status.History); n > 0 {
+		return &instance.Status.History[n-1]
+	}
+	return nil
+}
+
+// Reads the manifest of a previous revision back from its Secret
+func (r *ChartReconciler) storedRelease(instance *stablev1.Chart, revision int64) (*release, error) {
+	record := findRevision(instance, revision)
+	if record == nil {
+		return nil, &reasonError{
+			reason: reasonRevisionNotFound,
+			err:    fmt.Errorf("revision %d is not in the history", revision),
+		}
+	}
+	secret := &corev1.Secret{}
+	key := types.NamespacedName{Namespace: instance.Spec.NameSpaceSelector, Name: revisionSecretName(instance, revision)}
+	if err := r.Get(ctx, key, secret); err != nil {
+		if apierrs.IsNotFound(err) {
+			return nil, &reasonError{
+				reason: reasonRevisionNotFound,
+				err:    fmt.Errorf("manifest of revision %d not found", revision),
+			}
+		}
+		return nil, err
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(secret.Data[manifestKey]))
+	if err != nil {
+		return nil, err
+	}
+	manifest, err := ioutil.ReadAll(zr)
+	if err != nil {
+		return nil, err
+	}
+	return &release{
+		manifest:      manifest,
+		digest:        record.Digest,
+		valuesDigest:  record.ValuesDigest,
+		chartRevision: record.ChartRevision,
+		chart:         record.Chart,
+		chartVersion:  record.ChartVersion,
+		rollbackOf:    revision,
+	}, nil
+}
+
+// Records the release as the latest revision and stores its manifest, a
+// release with the same digest as the latest revision is a retry of it
+func (r *ChartReconciler) recordRevision(instance *stablev1.Chart, rel *release) error {
+	latest := latestRevision(instance)
+	if latest != nil && latest.Digest == rel.digest {
+		if latest.Outcome == stablev1.ReleaseFailed {
+			latest.Outcome = stablev1.ReleasePending
+			// a retry gets the full timeout to become healthy
+			instance.Status.HealthDeadline = nil
+		}
+		return nil
+	}
+
+	revision := int64(1)
+	description := "Install"
+	if latest != nil {
+		revision = latest.Revision + 1
+		description = "Upgrade"
+	}
+	if rel.rollbackOf != 0 {
+		description = fmt.Sprintf("Rollback to %d", rel.rollbackOf)
+	}
+	if err := r.storeManifest(instance, revision, rel.manifest); err != nil {
+		return err
+	}
+	instance.Status.Failures = 0
+	instance.Status.HealthDeadline = nil
+	sum := sha256.Sum256(rel.manifest)
+	instance.Status.History = append(instance.Status.History, stablev1.ReleaseRecord{
+		Revision:       revision,
+		ChartRevision:  rel.chartRevision,
+		Chart:          rel.chart,
+		ChartVersion:   rel.chartVersion,
+		Digest:         rel.digest,
+		ValuesDigest:   rel.valuesDigest,
+		ManifestDigest: hex.EncodeToString(sum[:]),
+		Time:           metav1.Now(),
+		Outcome:        stablev1.ReleasePending,
+		Description:    description,
+	})
+	if err := r.trimHistory(instance); err != nil {
+		return err
+	}
+	return r.UpdateStatus(instance)
+}
+
+// Stores the compressed manifest of a revision in a Secret owned by the chart
+func (r *ChartReconciler) storeManifest(instance *stablev1.Chart, revision int64, manifest []byte) error {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(manifest); err != nil {
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      revisionSecretName(instance, revision),
+			Namespace: instance.Spec.NameSpaceSelector,
+			Labels: map[string]string{
+				chartLabel:    instance.GetName(),
+				revisionLabel: strconv.FormatInt(revision, 10),
+			},
+		},
+		Data: map[string][]byte{manifestKey: buf.Bytes()},
+	}
+	if err := setOwner(instance, secret, r.Scheme); err != nil {
+		return err
+	}
+	err := r.Create(ctx, secret)
+	if !apierrs.IsAlreadyExists(err) {
+		return err
+	}
+	// left behind by a status update that failed
+	existing := &corev1.Secret{}
+	if err := r.Get(ctx, types.NamespacedName{Namespace: secret.Namespace, Name: secret.Name}, existing); err != nil {
+		return err
+	}
+	existing.Labels = secret.Labels
+	existing.Data = secret.Data
+	return r.Update(ctx, existing)
+}
+
+// Drops the oldest revisions beyond the history limit, the deployed revision
+// is always kept
+func (r *ChartReconciler) trimHistory(instance *stablev1.Chart) error {
+	max := defaultMaxHistory
+	if instance.Spec.MaxHistory > 0 {
+		max = int(instance.Spec.MaxHistory)
+	}
+	history := instance.Status.History
+	var kept []stablev1.ReleaseRecord
+	for i, record := range history {
+		if len(history)-i > max && record.Revision != instance.Status.CurrentRevision {
+			secret := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{
+				Name:      revisionSecretName(instance, record.Revision),
+				Namespace: instance.Spec.NameSpaceSelector,
+			}}
+			if err := ignoreNotFound(r.Delete(ctx, secret)); err != nil {
+				return err
+			}
+			continue
+		}
+		kept = append(kept, record)
+	}
+	instance.Status.History = kept
+	return nil
+}
+
+// Marks the latest revision deployed and the revisions deployed before it
+// superseded
+func deployedRevision(instance *stablev1.Chart) {
+	latest := latestRevision(instance)
+	if latest == nil {
+		return
+	}
+	for i := range instance.Status.History {
+		if instance.Status.History[i].Outcome == stablev1.ReleaseDeployed {
+			instance.Status.History[i].Outcome = stablev1.ReleaseSuperseded
+		}
+	}
+	latest.Outcome = stablev1.ReleaseDeployed
+	latest.Description = baseDescription(latest.Description)
+	instance.Status.CurrentRevision = latest.Revision
+	instance.Status.Failures = 0
+}
+
+// Marks the latest revision failed if it was being deployed, and returns
+// whether it was
+func failedRevision(instance *stablev1.Chart, err error) bool {
+	latest := latestRevision(instance)
+	if latest == nil || latest.Outcome != stablev1.ReleasePending {
+		return false
+	}
+	latest.Outcome = stablev1.ReleaseFailed
+	// retries of the revision replace the error of the previous attempt
+	latest.Description = fmt.Sprintf("%v%v%v", baseDescription(latest.Description), failedDescription, err)
+	return true
+}
+
+// Returns the description of a revision without the error of its last failed
+// attempt
+func baseDescription(description string) string {
+	return strings.SplitN(description, failedDescription, 2)[0]
+}
+
+// Fails an upgrade, with rollback on failure the deployed revision is
+// deployed again on the next reconcile in place of the failed chart and values
+func (r *ChartReconciler) failedUpgrade(instance *stablev1.Chart, conditionType string, rel *release, err error) (ctrl.Result, error) {
+	latest := latestRevision(instance)
+	rollback := instance.Spec.Rollback != nil && instance.Spec.Rollback.OnFailure
+	if !rollback || rel.rollbackOf != 0 || instance.Status.CurrentRevision == 0 ||
+		latest == nil || latest.Outcome != stablev1.ReleasePending {
+		return r.failed(instance, conditionType, err)
+	}
+	r.Log.Info("rolling back failed upgrade", "chart", instance.GetName(), "revision", instance.Status.CurrentRevision)
+	instance.Status.RolledBackDigest = rel.digest
+	if _, updateErr := r.failed(instance, conditionType, err); updateErr != nil && updateErr != err {
+		return ctrl.Result{}, updateErr
+	}
+	return ctrl.Result{Requeue: true}, nil
+}
@@ -0,0 +1,177 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"errors"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	stablev1 "github.com/Spazzy757/helm-operator/api/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrs "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var _ = Describe("release history", func() {
+	var (
+		r        *ChartReconciler
+		instance *stablev1.Chart
+	)
+
+	BeforeEach(func() {
+		instance = &stablev1.Chart{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "stable.helm.operator.io/v1", Kind: "Chart"},
+			ObjectMeta: metav1.ObjectMeta{Name: "foo", UID: "1234"},
+			Spec:       stablev1.ChartSpec{NameSpaceSelector: "apps"},
+		}
+		r = &ChartReconciler{
+			Client: fake.NewFakeClientWithScheme(testScheme(), instance),
+			Log:    ctrl.Log.WithName("test"),
+			Scheme: testScheme(),
+		}
+	})
+
+	deploy := func(digest, manifest string) {
+		Expect(r.recordRevision(instance, &release{manifest: []byte(manifest), digest: digest, chartRevision: "1.0.0"})).To(Succeed())
+		deployedRevision(instance)
+	}
+
+	secretExists := func(revision int64) bool {
+		err := r.Get(ctx, types.NamespacedName{Namespace: "apps", Name: revisionSecretName(instance, revision)}, &corev1.Secret{})
+		if apierrs.IsNotFound(err) {
+			return false
+		}
+		Expect(err).NotTo(HaveOccurred())
+		return true
+	}
+
+	It("should record a revision for each new release", func() {
+		deploy("a", "kind: ConfigMap")
+		deploy("a", "kind: ConfigMap")
+		deploy("b", "kind: Secret")
+
+		history := instance.Status.History
+		Expect(history).To(HaveLen(2))
+		Expect(history[0].Revision).To(Equal(int64(1)))
+		Expect(history[0].Outcome).To(Equal(stablev1.ReleaseSuperseded))
+		Expect(history[0].Description).To(Equal("Install"))
+		Expect(history[1].Revision).To(Equal(int64(2)))
+		Expect(history[1].Outcome).To(Equal(stablev1.ReleaseDeployed))
+		Expect(history[1].Description).To(Equal("Upgrade"))
+		Expect(history[1].ChartRevision).To(Equal("1.0.0"))
+		Expect(history[1].ManifestDigest).NotTo(BeEmpty())
+		Expect(instance.Status.CurrentRevision).To(Equal(int64(2)))
+	})
+
+	It("should read the manifest of a revision back", func() {
+		deploy("a", "kind: ConfigMap")
+		deploy("b", "kind: Secret")
+
+		rel, err := r.storedRelease(instance, 1)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(rel.manifest)).To(Equal("kind: ConfigMap"))
+		Expect(rel.digest).To(Equal("a"))
+		Expect(rel.rollbackOf).To(Equal(int64(1)))
+
+		_, err = r.storedRelease(instance, 7)
+		Expect(failureReason(err)).To(Equal(reasonRevisionNotFound))
+
+		deploy(rel.digest, string(rel.manifest))
+		Expect(latestRevision(instance).Description).To(Equal("Upgrade"))
+	})
+
+	It("should describe rollbacks", func() {
+		deploy("a", "kind: ConfigMap")
+		deploy("b", "kind: Secret")
+		rel, err := r.storedRelease(instance, 1)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(r.recordRevision(instance, rel)).To(Succeed())
+		Expect(latestRevision(instance).Description).To(Equal("Rollback to 1"))
+		Expect(latestRevision(instance).Revision).To(Equal(int64(3)))
+	})
+
+	It("should only keep maxHistory revisions", func() {
+		instance.Spec.MaxHistory = 2
+		deploy("a", "a")
+		deploy("b", "b")
+		deploy("c", "c")
+
+		Expect(instance.Status.History).To(HaveLen(2))
+		Expect(instance.Status.History[0].Revision).To(Equal(int64(2)))
+		Expect(secretExists(1)).To(BeFalse())
+		Expect(secretExists(2)).To(BeTrue())
+		Expect(secretExists(3)).To(BeTrue())
+	})
+
+	It("should deploy the stored revision when pinned or rolled back", func() {
+		deploy("a", "kind: ConfigMap")
+		rendered := &release{manifest: []byte("kind: Secret"), digest: "b"}
+
+		Expect(r.targetRelease(instance, rendered)).To(Equal(rendered))
+
+		instance.Status.RolledBackDigest = "b"
+		rel, err := r.targetRelease(instance, rendered)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(rel.digest).To(Equal("a"))
+
+		instance.Status.RolledBackDigest = ""
+		instance.Spec.Rollback = &stablev1.Rollback{Revision: 1}
+		rel, err = r.targetRelease(instance, rendered)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(rel.rollbackOf).To(Equal(int64(1)))
+	})
+
+	It("should only keep the error of the last attempt of a revision", func() {
+		deploy("a", "kind: ConfigMap")
+		upgrade := &release{manifest: []byte("kind: Secret"), digest: "b"}
+		for i := 0; i < 2; i++ {
+			Expect(r.recordRevision(instance, upgrade)).To(Succeed())
+			Expect(failedRevision(instance, errors.New("unhealthy"))).To(BeTrue())
+			Expect(latestRevision(instance).Description).To(Equal("Upgrade failed: unhealthy"))
+		}
+
+		Expect(r.recordRevision(instance, upgrade)).To(Succeed())
+		deployedRevision(instance)
+		Expect(latestRevision(instance).Description).To(Equal("Upgrade"))
+	})
+
+	It("should roll back failed upgrades when asked to", func() {
+		deploy("a", "kind: ConfigMap")
+		upgrade := &release{manifest: []byte("kind: Secret"), digest: "b"}
+		Expect(r.recordRevision(instance, upgrade)).To(Succeed())
+
+		result, err := r.failedUpgrade(instance, stablev1.ChartReady, upgrade, errors.New("unhealthy"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.Requeue).To(BeFalse())
+		Expect(result.RequeueAfter).To(Equal(defaultBackoff))
+		Expect(latestRevision(instance).Outcome).To(Equal(stablev1.ReleaseFailed))
+		Expect(instance.Status.RolledBackDigest).To(BeEmpty())
+
+		instance.Spec.Rollback = &stablev1.Rollback{OnFailure: true}
+		Expect(r.recordRevision(instance, upgrade)).To(Succeed())
+		Expect(latestRevision(instance).Outcome).To(Equal(stablev1.ReleasePending))
+		result, err = r.failedUpgrade(instance, stablev1.ChartReady, upgrade, errors.New("unhealthy"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.Requeue).To(BeTrue())
+		Expect(instance.Status.RolledBackDigest).To(Equal("b"))
+		Expect(latestRevision(instance).Outcome).To(Equal(stablev1.ReleaseFailed))
+	})
+})
@@ -0,0 +1,322 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	stablev1 "github.com/Spazzy757/helm-operator/api/v1"
+	apierrs "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	hookAnnotation             = "helm.sh/hook"
+	hookWeightAnnotation       = "helm.sh/hook-weight"
+	hookDeletePolicyAnnotation = "helm.sh/hook-delete-policy"
+
+	hookPreInstall   = "pre-install"
+	hookPostInstall  = "post-install"
+	hookPreUpgrade   = "pre-upgrade"
+	hookPostUpgrade  = "post-upgrade"
+	hookPreDelete    = "pre-delete"
+	hookPostDelete   = "post-delete"
+	hookPreRollback  = "pre-rollback"
+	hookPostRollback = "post-rollback"
+	// Helm 2 charts ship their CRDs as crd-install hooks, these are applied
+	// like any other CRD
+	hookCRDInstall = "crd-install"
+
+	hookBeforeCreation = "before-hook-creation"
+	hookSucceeded      = "hook-succeeded"
+	hookFailed         = "hook-failed"
+
+	// A hook failed or did not complete in time
+	reasonHookFailed = "HookFailed"
+)
+
+// How often a running hook is checked on
+var hookPollInterval = 5 * time.Second
+
+// Returns the lifecycle events a rendered object is a hook for
+func hookEvents(u *unstructured.Unstructured) []string {
+	var events []string
+	for _, event := range strings.Split(u.GetAnnotations()[hookAnnotation], ",") {
+		if event = strings.TrimSpace(event); event != "" {
+			events = append(events, event)
+		}
+	}
+	return events
+}
+
+// Splits rendered objects into hooks and resources of the release, keeping
+// their order
+func splitHooks(objects []*unstructured.Unstructured) (hooks, rest []*unstructured.Unstructured) {
+	for _, u := range objects {
+		events := hookEvents(u)
+		if len(events) == 0 || (len(events) == 1 && events[0] == hookCRDInstall) {
+			rest = append(rest, u)
+		} else {
+			hooks = append(hooks, u)
+		}
+	}
+	return hooks, rest
+}
+
+// Returns the hooks for an event ordered by weight, hooks of the same weight
+// keep the order they were passed in
+func hooksFor(hooks []*unstructured.Unstructured, event string) []*unstructured.Unstructured {
+	var selected []*unstructured.Unstructured
+	for _, u := range hooks {
+		if containsString(hookEvents(u), event) {
+			selected = append(selected, u)
+		}
+	}
+	sort.SliceStable(selected, func(i, j int) bool {
+		return hookWeight(selected[i]) < hookWeight(selected[j])
+	})
+	return selected
+}
+
+// Returns the weight of a hook, hooks without a valid weight weigh 0
+func hookWeight(u *unstructured.Unstructured) int {
+	weight, err := strconv.Atoi(strings.TrimSpace(u.GetAnnotations()[hookWeightAnnotation]))
+	if err != nil {
+		return 0
+	}
+	return weight
+}
+
+// Returns the delete policies of a hook, before-hook-creation when none is set
+func hookDeletePolicies(u *unstructured.Unstructured) []string {
+	var policies []string
+	for _, policy := range strings.Split(u.GetAnnotations()[hookDeletePolicyAnnotation], ",") {
+		if policy = strings.TrimSpace(policy); policy != "" {
+			policies = append(policies, policy)
+		}
+	}
+	if len(policies) == 0 {
+		return []string{hookBeforeCreation}
+	}
+	return policies
+}
+
+// Runs the hooks of an event one after another. Hooks are not waited for, a
+// hook that is still running is checked again on the next reconcile and done
+// is false until every hook completed. Hooks that already succeeded for the
+// digest are not run again
+func (r *ChartReconciler) runHooks(instance *stablev1.Chart, hooks []*unstructured.Unstructured, event, digest string) (bool, error) {
+	for _, hook := range hooksFor(hooks, event) {
+		done, err := r.runHook(instance, hook.DeepCopy(), event, digest)
+		if err != nil || !done {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// Creates a single hook or checks on the one created by a previous reconcile,
+// the result is recorded in the status of the instance
+func (r *ChartReconciler) runHook(instance *stablev1.Chart, hook *unstructured.Unstructured, event, digest string) (bool, error) {
+	if err := setOwner(instance, hook, r.Scheme); err != nil {
+		return false, err
+	}
+	if err := r.setNamespace(instance, hook); err != nil {
+		return false, err
+	}
+	status := findHookStatus(instance, hook, event)
+	if status == nil || status.Digest != digest || status.Phase == stablev1.HookPhaseFailed {
+		return r.startHook(instance, hook, event, digest)
+	}
+	if status.Phase == stablev1.HookPhaseSucceeded {
+		return true, nil
+	}
+
+	key, err := client.ObjectKeyFromObject(hook)
+	if err != nil {
+		return false, err
+	}
+	live := &unstructured.Unstructured{}
+	live.SetGroupVersionKind(hook.GroupVersionKind())
+	if err := r.Get(ctx, key, live); err != nil {
+		if apierrs.IsNotFound(err) {
+			// deleted while it was running
+			return r.startHook(instance, hook, event, digest)
+		}
+		return false, err
+	}
+	phase, message := hookPhase(live)
+	if phase == stablev1.HookPhaseRunning {
+		if status.StartTime == nil {
+			now := metav1.Now()
+			status.StartTime = &now
+		}
+		if time.Since(status.StartTime.Time) < timeout(instance) {
+			return false, nil
+		}
+		phase, message = stablev1.HookPhaseFailed, fmt.Sprintf("did not complete within %v", timeout(instance))
+	}
+	return r.completeHook(instance, hook, event, digest, phase, message)
+}
+
+// Creates a hook and records it as running, hooks of kinds other than Jobs
+// and Pods complete right away
+func (r *ChartReconciler) startHook(instance *stablev1.Chart, hook *unstructured.Unstructured, event, digest string) (bool, error) {
+	if containsString(hookDeletePolicies(hook), hookBeforeCreation) {
+		gone, err := r.deleteHook(hook)
+		if err != nil || !gone {
+			return false, err
+		}
+	}
+	if err := r.Create(ctx, hook); err != nil {
+		return false, err
+	}
+	r.Log.V(1).Info(fmt.Sprintf("Running %v hook: %v %v", event, hook.GetKind(), hook.GetName()))
+	now := metav1.Now()
+	setHookStatus(instance, stablev1.HookStatus{
+		Name:      hook.GetName(),
+		Kind:      hook.GetKind(),
+		Event:     event,
+		Phase:     stablev1.HookPhaseRunning,
+		Digest:    digest,
+		StartTime: &now,
+	})
+	if phase, message := hookPhase(hook); phase != stablev1.HookPhaseRunning {
+		return r.completeHook(instance, hook, event, digest, phase, message)
+	}
+	if err := r.UpdateStatus(instance); err != nil {
+		return false, err
+	}
+	return false, nil
+}
+
+// Records the result of a completed hook and applies its delete policy
+func (r *ChartReconciler) completeHook(instance *stablev1.Chart, hook *unstructured.Unstructured, event, digest string, phase stablev1.HookPhase, message string) (bool, error) {
+	var started *metav1.Time
+	if status := findHookStatus(instance, hook, event); status != nil {
+		started = status.StartTime
+	}
+	now := metav1.Now()
+	setHookStatus(instance, stablev1.HookStatus{
+		Name:           hook.GetName(),
+		Kind:           hook.GetKind(),
+		Event:          event,
+		Phase:          phase,
+		Message:        message,
+		Digest:         digest,
+		StartTime:      started,
+		CompletionTime: &now,
+	})
+	policies := hookDeletePolicies(hook)
+	if (phase == stablev1.HookPhaseSucceeded && containsString(policies, hookSucceeded)) ||
+		(phase == stablev1.HookPhaseFailed && containsString(policies, hookFailed)) {
+		if _, err := r.deleteHook(hook); err != nil {
+			return false, err
+		}
+	}
+	if err := r.UpdateStatus(instance); err != nil {
+		return false, err
+	}
+	if phase == stablev1.HookPhaseFailed {
+		return false, &reasonError{
+			reason: reasonHookFailed,
+			err:    fmt.Errorf("%v hook %v %v failed: %v", event, hook.GetKind(), hook.GetName(), message),
+		}
+	}
+	return true, nil
+}
+
+// Deletes a hook along with its pods, gone is false while the hook is still
+// being deleted
+func (r *ChartReconciler) deleteHook(hook *unstructured.Unstructured) (bool, error) {
+	key, err := client.ObjectKeyFromObject(hook)
+	if err != nil {
+		return false, err
+	}
+	live := &unstructured.Unstructured{}
+	live.SetGroupVersionKind(hook.GroupVersionKind())
+	if err := r.Get(ctx, key, live); err != nil {
+		return apierrs.IsNotFound(err), ignoreNotFound(err)
+	}
+	if err := r.Delete(ctx, live, client.PropagationPolicy(metav1.DeletePropagationBackground)); err != nil {
+		return apierrs.IsNotFound(err), ignoreNotFound(err)
+	}
+	if err := r.Get(ctx, key, live); err != nil {
+		return apierrs.IsNotFound(err), ignoreNotFound(err)
+	}
+	return false, nil
+}
+
+// Returns the phase of a live hook, Jobs and Pods run until they succeed or
+// fail while any other kind is complete once created
+func hookPhase(live *unstructured.Unstructured) (stablev1.HookPhase, string) {
+	switch live.GetKind() {
+	case "Job":
+		conditions, _, _ := unstructured.NestedSlice(live.Object, "status", "conditions")
+		for _, c := range conditions {
+			condition, ok := c.(map[string]interface{})
+			if !ok || condition["status"] != "True" {
+				continue
+			}
+			switch condition["type"] {
+			case "Complete":
+				return stablev1.HookPhaseSucceeded, ""
+			case "Failed":
+				message, _ := condition["message"].(string)
+				return stablev1.HookPhaseFailed, message
+			}
+		}
+		return stablev1.HookPhaseRunning, ""
+	case "Pod":
+		phase, _, _ := unstructured.NestedString(live.Object, "status", "phase")
+		switch phase {
+		case "Succeeded":
+			return stablev1.HookPhaseSucceeded, ""
+		case "Failed":
+			message, _, _ := unstructured.NestedString(live.Object, "status", "message")
+			return stablev1.HookPhaseFailed, message
+		}
+		return stablev1.HookPhaseRunning, ""
+	}
+	return stablev1.HookPhaseSucceeded, ""
+}
+
+// Returns the recorded result of a hook for an event
+func findHookStatus(instance *stablev1.Chart, hook *unstructured.Unstructured, event string) *stablev1.HookStatus {
+	for i, status := range instance.Status.Hooks {
+		if status.Kind == hook.GetKind() && status.Name == hook.GetName() && status.Event == event {
+			return &instance.Status.Hooks[i]
+		}
+	}
+	return nil
+}
+
+// Records the result of a hook, replacing the previous one for its event
+func setHookStatus(instance *stablev1.Chart, status stablev1.HookStatus) {
+	for i, s := range instance.Status.Hooks {
+		if s.Kind == status.Kind && s.Name == status.Name && s.Event == status.Event {
+			instance.Status.Hooks[i] = status
+			return
+		}
+	}
+	instance.Status.Hooks = append(instance.Status.Hooks, status)
+}
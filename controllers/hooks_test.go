@@ -0,0 +1,184 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	stablev1 "github.com/Spazzy757/helm-operator/api/v1"
+	apierrs "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// Builds a rendered hook with the given annotations
+func hook(apiVersion, kind, name string, annotations map[string]string) *unstructured.Unstructured {
+	u := &unstructured.Unstructured{}
+	u.SetAPIVersion(apiVersion)
+	u.SetKind(kind)
+	u.SetName(name)
+	u.SetAnnotations(annotations)
+	return u
+}
+
+var _ = Describe("hooks", func() {
+	It("should split hooks from the resources of the release", func() {
+		cm := hook("v1", "ConfigMap", "plain", nil)
+		job := hook("batch/v1", "Job", "migrate", map[string]string{hookAnnotation: "pre-install, pre-upgrade"})
+		crd := hook("apiextensions.k8s.io/v1beta1", "CustomResourceDefinition", "widgets", map[string]string{hookAnnotation: "crd-install"})
+		hooks, rest := splitHooks([]*unstructured.Unstructured{cm, job, crd})
+		Expect(hooks).To(Equal([]*unstructured.Unstructured{job}))
+		Expect(rest).To(Equal([]*unstructured.Unstructured{cm, crd}))
+		Expect(hookEvents(job)).To(Equal([]string{hookPreInstall, hookPreUpgrade}))
+	})
+
+	It("should order the hooks of an event by weight", func() {
+		a := hook("v1", "ConfigMap", "a", map[string]string{hookAnnotation: "post-install", hookWeightAnnotation: "5"})
+		b := hook("v1", "ConfigMap", "b", map[string]string{hookAnnotation: "post-install"})
+		c := hook("v1", "ConfigMap", "c", map[string]string{hookAnnotation: "post-install", hookWeightAnnotation: "-1"})
+		d := hook("v1", "ConfigMap", "d", map[string]string{hookAnnotation: "pre-install"})
+		Expect(hooksFor([]*unstructured.Unstructured{a, b, c, d}, hookPostInstall)).To(Equal([]*unstructured.Unstructured{c, b, a}))
+	})
+
+	It("should delete hooks before creating them by default", func() {
+		Expect(hookDeletePolicies(hook("v1", "ConfigMap", "a", nil))).To(Equal([]string{hookBeforeCreation}))
+		Expect(hookDeletePolicies(hook("v1", "ConfigMap", "a", map[string]string{
+			hookDeletePolicyAnnotation: "hook-succeeded,hook-failed",
+		}))).To(Equal([]string{hookSucceeded, hookFailed}))
+	})
+
+	It("should tell the phase of Jobs and Pods", func() {
+		job := hook("batch/v1", "Job", "migrate", nil)
+		Expect(hookPhase(job)).To(Equal(stablev1.HookPhaseRunning))
+		Expect(unstructured.SetNestedSlice(job.Object, []interface{}{map[string]interface{}{
+			"type": "Failed", "status": "True", "message": "BackoffLimitExceeded",
+		}}, "status", "conditions")).To(Succeed())
+		phase, message := hookPhase(job)
+		Expect(phase).To(Equal(stablev1.HookPhaseFailed))
+		Expect(message).To(Equal("BackoffLimitExceeded"))
+
+		pod := hook("v1", "Pod", "check", nil)
+		Expect(unstructured.SetNestedField(pod.Object, "Succeeded", "status", "phase")).To(Succeed())
+		Expect(hookPhase(pod)).To(Equal(stablev1.HookPhaseSucceeded))
+	})
+
+	Context("runHooks", func() {
+		var (
+			r        *ChartReconciler
+			instance *stablev1.Chart
+		)
+
+		BeforeEach(func() {
+			instance = &stablev1.Chart{
+				TypeMeta:   metav1.TypeMeta{APIVersion: "stable.helm.operator.io/v1", Kind: "Chart"},
+				ObjectMeta: metav1.ObjectMeta{Name: "foo", UID: "1234"},
+				Spec: stablev1.ChartSpec{
+					NameSpaceSelector: "apps",
+					Timeout:           &metav1.Duration{Duration: 50 * time.Millisecond},
+				},
+			}
+			r = &ChartReconciler{
+				Client: fake.NewFakeClientWithScheme(testScheme(), instance),
+				Log:    ctrl.Log.WithName("test"),
+				Scheme: testScheme(),
+				Mapper: testMapper(),
+			}
+		})
+
+		getHook := func(apiVersion, kind, name string) error {
+			u := &unstructured.Unstructured{}
+			u.SetAPIVersion(apiVersion)
+			u.SetKind(kind)
+			return r.Get(ctx, types.NamespacedName{Namespace: "apps", Name: name}, u)
+		}
+
+		It("should run hooks once per digest and apply their delete policy", func() {
+			kept := hook("v1", "ConfigMap", "kept", map[string]string{hookAnnotation: "pre-install"})
+			deleted := hook("v1", "ConfigMap", "deleted", map[string]string{
+				hookAnnotation:             "pre-install",
+				hookDeletePolicyAnnotation: "hook-succeeded",
+			})
+			hooks := []*unstructured.Unstructured{kept, deleted}
+			Expect(r.runHooks(instance, hooks, hookPreInstall, "abc")).To(BeTrue())
+
+			Expect(getHook("v1", "ConfigMap", "kept")).To(Succeed())
+			Expect(apierrs.IsNotFound(getHook("v1", "ConfigMap", "deleted"))).To(BeTrue())
+			Expect(instance.Status.Hooks).To(HaveLen(2))
+			for _, status := range instance.Status.Hooks {
+				Expect(status.Phase).To(Equal(stablev1.HookPhaseSucceeded))
+				Expect(status.Event).To(Equal(hookPreInstall))
+				Expect(status.Digest).To(Equal("abc"))
+			}
+
+			// a hook that succeeded for the digest is skipped, even if it would fail now
+			Expect(r.Delete(ctx, instance)).To(Succeed())
+			Expect(r.runHooks(instance, hooks, hookPreInstall, "abc")).To(BeTrue())
+		})
+
+		It("should pick a running hook back up once it completes", func() {
+			job := hook("batch/v1", "Job", "migrate", map[string]string{
+				hookAnnotation:             "pre-install",
+				hookDeletePolicyAnnotation: "hook-succeeded",
+			})
+			hooks := []*unstructured.Unstructured{job}
+			Expect(r.runHooks(instance, hooks, hookPreInstall, "abc")).To(BeFalse())
+			Expect(instance.Status.Hooks).To(HaveLen(1))
+			Expect(instance.Status.Hooks[0].Phase).To(Equal(stablev1.HookPhaseRunning))
+			Expect(instance.Status.Hooks[0].StartTime).NotTo(BeNil())
+
+			// still running on the next reconcile
+			Expect(r.runHooks(instance, hooks, hookPreInstall, "abc")).To(BeFalse())
+
+			live := &unstructured.Unstructured{}
+			live.SetAPIVersion("batch/v1")
+			live.SetKind("Job")
+			Expect(r.Get(ctx, types.NamespacedName{Namespace: "apps", Name: "migrate"}, live)).To(Succeed())
+			Expect(unstructured.SetNestedSlice(live.Object, []interface{}{map[string]interface{}{
+				"type": "Complete", "status": "True",
+			}}, "status", "conditions")).To(Succeed())
+			Expect(r.Update(ctx, live)).To(Succeed())
+
+			Expect(r.runHooks(instance, hooks, hookPreInstall, "abc")).To(BeTrue())
+			Expect(instance.Status.Hooks[0].Phase).To(Equal(stablev1.HookPhaseSucceeded))
+			Expect(instance.Status.Hooks[0].CompletionTime).NotTo(BeNil())
+			Expect(apierrs.IsNotFound(getHook("batch/v1", "Job", "migrate"))).To(BeTrue())
+		})
+
+		It("should fail when a hook does not complete in time", func() {
+			job := hook("batch/v1", "Job", "migrate", map[string]string{
+				hookAnnotation:             "pre-upgrade",
+				hookDeletePolicyAnnotation: "hook-failed",
+			})
+			hooks := []*unstructured.Unstructured{job}
+			Expect(r.runHooks(instance, hooks, hookPreUpgrade, "abc")).To(BeFalse())
+			started := metav1.NewTime(time.Now().Add(-time.Minute))
+			instance.Status.Hooks[0].StartTime = &started
+
+			done, err := r.runHooks(instance, hooks, hookPreUpgrade, "abc")
+			Expect(done).To(BeFalse())
+			Expect(failureReason(err)).To(Equal(reasonHookFailed))
+			Expect(instance.Status.Hooks).To(HaveLen(1))
+			Expect(instance.Status.Hooks[0].Phase).To(Equal(stablev1.HookPhaseFailed))
+			Expect(apierrs.IsNotFound(getHook("batch/v1", "Job", "migrate"))).To(BeTrue())
+		})
+	})
+})
@@ -0,0 +1,114 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"fmt"
+
+	stablev1 "github.com/Spazzy757/helm-operator/api/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrs "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/rest"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// The service account of a chart cannot be impersonated
+	reasonImpersonationFailed = "ImpersonationFailed"
+)
+
+// impersonatingClient acts as the service account of a chart, while the
+// status of the chart is still written with the credentials of the manager
+type impersonatingClient struct {
+	client.Client
+	status client.StatusWriter
+}
+
+// Status writes the status of the chart as the manager
+func (c *impersonatingClient) Status() client.StatusWriter {
+	return c.status
+}
+
+// Returns the config of the manager impersonating the service account of the
+// chart in nameSpaceSelector
+func impersonationConfig(config *rest.Config, instance *stablev1.Chart) (*rest.Config, error) {
+	namespace := serviceAccountNamespace(instance)
+	if namespace == "" {
+		return nil, &reasonError{
+			reason: reasonImpersonationFailed,
+			err:    fmt.Errorf("service account %s needs a nameSpaceSelector", instance.Spec.ServiceAccountName),
+		}
+	}
+	impersonated := rest.CopyConfig(config)
+	impersonated.Impersonate = rest.ImpersonationConfig{
+		UserName: fmt.Sprintf("system:serviceaccount:%s:%s", namespace, instance.Spec.ServiceAccountName),
+	}
+	return impersonated, nil
+}
+
+// Returns the namespace of the service account of a chart
+func serviceAccountNamespace(instance *stablev1.Chart) string {
+	if isNamespaced(instance) {
+		return instance.GetNamespace()
+	}
+	return instance.Spec.NameSpaceSelector
+}
+
+// Returns a reconciler that manages the resources of the chart as its service
+// account, or the reconciler itself for charts without one
+func (r *ChartReconciler) forServiceAccount(instance *stablev1.Chart) (*ChartReconciler, error) {
+	if instance.Spec.ServiceAccountName == "" {
+		return r, nil
+	}
+	if r.Config == nil {
+		return nil, &reasonError{
+			reason: reasonImpersonationFailed,
+			err:    fmt.Errorf("no config to impersonate service account %s with", instance.Spec.ServiceAccountName),
+		}
+	}
+	config, err := impersonationConfig(r.Config, instance)
+	if err != nil {
+		return nil, err
+	}
+	c, err := client.New(config, client.Options{Scheme: r.Scheme, Mapper: r.Mapper})
+	if err != nil {
+		return nil, &reasonError{reason: reasonImpersonationFailed, err: err}
+	}
+	impersonated := *r
+	impersonated.Client = &impersonatingClient{Client: c, status: r.Status()}
+	return &impersonated, nil
+}
+
+// Returns the reconciler deleting the resources of a chart, the manager itself
+// once the service account cannot be impersonated or is gone so the chart can
+// still be deleted
+func (r *ChartReconciler) forDeletion(instance *stablev1.Chart) *ChartReconciler {
+	rc, err := r.forServiceAccount(instance)
+	if err != nil {
+		r.Log.Info("deleting chart as the manager", "chart", instance.GetName(), "reason", err.Error())
+		return r
+	}
+	if rc == r {
+		return r
+	}
+	key := types.NamespacedName{Namespace: serviceAccountNamespace(instance), Name: instance.Spec.ServiceAccountName}
+	if err := r.Get(ctx, key, &corev1.ServiceAccount{}); apierrs.IsNotFound(err) {
+		r.Log.Info("deleting chart as the manager, its service account is gone", "chart", instance.GetName())
+		return r
+	}
+	return rc
+}
@@ -0,0 +1,88 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	stablev1 "github.com/Spazzy757/helm-operator/api/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/rest"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var _ = Describe("service account impersonation", func() {
+	var (
+		r        *ChartReconciler
+		instance *stablev1.Chart
+	)
+
+	BeforeEach(func() {
+		instance = &stablev1.Chart{
+			ObjectMeta: metav1.ObjectMeta{Name: "foo"},
+			Spec:       stablev1.ChartSpec{NameSpaceSelector: "apps", ServiceAccountName: "deployer"},
+		}
+		r = &ChartReconciler{
+			Client: fake.NewFakeClientWithScheme(testScheme()),
+			Log:    ctrl.Log.WithName("test"),
+			Scheme: testScheme(),
+			Mapper: testMapper(),
+			Config: &rest.Config{Host: "https://kubernetes.example.com", BearerToken: "manager"},
+		}
+	})
+
+	It("should impersonate the service account in nameSpaceSelector", func() {
+		config, err := impersonationConfig(r.Config, instance)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(config.Impersonate.UserName).To(Equal("system:serviceaccount:apps:deployer"))
+		Expect(config.Host).To(Equal(r.Config.Host))
+		Expect(r.Config.Impersonate.UserName).To(BeEmpty())
+
+		instance.Spec.NameSpaceSelector = ""
+		_, err = impersonationConfig(r.Config, instance)
+		Expect(failureReason(err)).To(Equal(reasonImpersonationFailed))
+	})
+
+	It("should write the status of the chart as the manager", func() {
+		rc, err := r.forServiceAccount(instance)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(rc).NotTo(BeIdenticalTo(r))
+		Expect(rc.Client).NotTo(BeIdenticalTo(r.Client))
+		Expect(rc.Status()).To(Equal(r.Status()))
+	})
+
+	It("should retry charts whose service account cannot be impersonated", func() {
+		Expect(stalledReasons[reasonImpersonationFailed]).To(BeFalse())
+	})
+
+	It("should delete charts as the manager once their service account is gone", func() {
+		Expect(r.forDeletion(instance)).To(BeIdenticalTo(r))
+
+		Expect(r.Create(ctx, &corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Name: "deployer", Namespace: "apps"}})).To(Succeed())
+		Expect(r.forDeletion(instance)).NotTo(BeIdenticalTo(r))
+
+		r.Config = nil
+		Expect(r.forDeletion(instance)).To(BeIdenticalTo(r))
+	})
+
+	It("should use the manager for charts without a service account", func() {
+		instance.Spec.ServiceAccountName = ""
+		Expect(r.forServiceAccount(instance)).To(BeIdenticalTo(r))
+	})
+})
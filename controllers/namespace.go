@@ -0,0 +1,158 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"fmt"
+
+	stablev1 "github.com/Spazzy757/helm-operator/api/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrs "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// Reasons of failures to scope a rendered resource or create its namespace
+const (
+	reasonUnknownKind        = "UnknownKind"
+	reasonNamespaceForbidden = "NamespaceForbidden"
+	reasonNamespaceFailed    = "NamespaceFailed"
+)
+
+// Sets the namespace of a rendered resource from its scope: cluster-scoped
+// resources get none, namespaced resources without one are deployed to
+// nameSpaceSelector and explicit namespaces are handled by the namespace policy
+func (r *ChartReconciler) setNamespace(instance *stablev1.Chart, u *unstructured.Unstructured) error {
+	gvk := u.GroupVersionKind()
+	mapping, err := r.Mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	if meta.IsNoMatchError(err) {
+		// the kind may have been added to the cluster since the last discovery
+		r.resetMapper()
+		mapping, err = r.Mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	}
+	if err != nil {
+		if meta.IsNoMatchError(err) {
+			return &reasonError{reason: reasonUnknownKind, err: err}
+		}
+		return err
+	}
+	if mapping.Scope.Name() == meta.RESTScopeNameRoot {
+		if isNamespaced(instance) {
+			return &reasonError{
+				reason: reasonNamespaceForbidden,
+				err:    fmt.Errorf("%s %s is cluster-scoped, namespaced charts can only deploy to %s", gvk.Kind, u.GetName(), instance.GetNamespace()),
+			}
+		}
+		u.SetNamespace("")
+		return nil
+	}
+
+	namespace := u.GetNamespace()
+	if namespace == "" || namespace == instance.Spec.NameSpaceSelector {
+		u.SetNamespace(instance.Spec.NameSpaceSelector)
+		return nil
+	}
+	policy := instance.Spec.NamespacePolicy
+	if isNamespaced(instance) && policy != stablev1.NamespacePolicyOverride {
+		policy = stablev1.NamespacePolicyForbid
+	}
+	switch policy {
+	case stablev1.NamespacePolicyOverride:
+		u.SetNamespace(instance.Spec.NameSpaceSelector)
+	case stablev1.NamespacePolicyForbid:
+		return &reasonError{
+			reason: reasonNamespaceForbidden,
+			err:    fmt.Errorf("%s %s targets namespace %s, only %s is allowed", gvk.Kind, u.GetName(), namespace, instance.Spec.NameSpaceSelector),
+		}
+	}
+	return nil
+}
+
+// Creates nameSpaceSelector when createNamespace is set and it does not exist
+// yet, the labels and annotations of namespaces the operator created are kept
+// in sync with the chart
+func (r *ChartReconciler) ensureNamespace(instance *stablev1.Chart) error {
+	name := instance.Spec.NameSpaceSelector
+	if !instance.Spec.CreateNamespace || name == "" {
+		return nil
+	}
+	metadata := instance.Spec.NamespaceMetadata
+	if metadata == nil {
+		metadata = &stablev1.NamespaceMetadata{}
+	}
+
+	ns := &corev1.Namespace{}
+	if err := r.Get(ctx, types.NamespacedName{Name: name}, ns); err != nil {
+		if !apierrs.IsNotFound(err) {
+			return err
+		}
+		ns = &corev1.Namespace{
+			TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "Namespace"},
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        name,
+				Labels:      metadata.Labels,
+				Annotations: metadata.Annotations,
+			},
+		}
+		if err := r.Create(ctx, ns); err != nil {
+			return &reasonError{reason: reasonNamespaceFailed, err: fmt.Errorf("unable to create namespace %s: %v", name, err)}
+		}
+		instance.Status.CreatedNamespace = name
+		return r.UpdateStatus(instance)
+	}
+
+	if instance.Status.CreatedNamespace != name {
+		return nil
+	}
+	changed := false
+	if ns.Labels == nil && len(metadata.Labels) > 0 {
+		ns.Labels = map[string]string{}
+	}
+	for k, v := range metadata.Labels {
+		if ns.Labels[k] != v {
+			ns.Labels[k] = v
+			changed = true
+		}
+	}
+	if ns.Annotations == nil && len(metadata.Annotations) > 0 {
+		ns.Annotations = map[string]string{}
+	}
+	for k, v := range metadata.Annotations {
+		if ns.Annotations[k] != v {
+			ns.Annotations[k] = v
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+	if err := r.Update(ctx, ns); err != nil {
+		return &reasonError{reason: reasonNamespaceFailed, err: fmt.Errorf("unable to update namespace %s: %v", name, err)}
+	}
+	return nil
+}
+
+// Deletes the namespace created for the chart when its deletion policy says so
+func (r *ChartReconciler) deleteNamespace(instance *stablev1.Chart) error {
+	name := instance.Status.CreatedNamespace
+	if name == "" || instance.Spec.NamespaceDeletionPolicy != stablev1.NamespaceDeletionPolicyDelete {
+		return nil
+	}
+	ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name}}
+	return ignoreNotFound(r.Delete(ctx, ns))
+}
@@ -0,0 +1,164 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	stablev1 "github.com/Spazzy757/helm-operator/api/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrs "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// Mapper knowing ConfigMaps as namespaced and ClusterRoles as cluster-scoped
+func testMapper() meta.RESTMapper {
+	mapper := meta.NewDefaultRESTMapper(nil)
+	mapper.Add(schema.GroupVersionKind{Version: "v1", Kind: "ConfigMap"}, meta.RESTScopeNamespace)
+	mapper.Add(schema.GroupVersionKind{Group: "batch", Version: "v1", Kind: "Job"}, meta.RESTScopeNamespace)
+	mapper.Add(schema.GroupVersionKind{Group: "rbac.authorization.k8s.io", Version: "v1", Kind: "ClusterRole"}, meta.RESTScopeRoot)
+	return mapper
+}
+
+var _ = Describe("setNamespace", func() {
+	var (
+		r        *ChartReconciler
+		instance *stablev1.Chart
+	)
+
+	object := func(apiVersion, kind, namespace string) *unstructured.Unstructured {
+		u := &unstructured.Unstructured{}
+		u.SetAPIVersion(apiVersion)
+		u.SetKind(kind)
+		u.SetName("foo")
+		u.SetNamespace(namespace)
+		return u
+	}
+
+	BeforeEach(func() {
+		r = &ChartReconciler{Mapper: testMapper()}
+		instance = &stablev1.Chart{Spec: stablev1.ChartSpec{NameSpaceSelector: "apps"}}
+	})
+
+	It("should not namespace cluster-scoped resources", func() {
+		u := object("rbac.authorization.k8s.io/v1", "ClusterRole", "apps")
+		Expect(r.setNamespace(instance, u)).To(Succeed())
+		Expect(u.GetNamespace()).To(BeEmpty())
+	})
+
+	It("should deploy resources without a namespace to nameSpaceSelector", func() {
+		u := object("v1", "ConfigMap", "")
+		Expect(r.setNamespace(instance, u)).To(Succeed())
+		Expect(u.GetNamespace()).To(Equal("apps"))
+	})
+
+	It("should keep explicit namespaces by default", func() {
+		u := object("v1", "ConfigMap", "monitoring")
+		Expect(r.setNamespace(instance, u)).To(Succeed())
+		Expect(u.GetNamespace()).To(Equal("monitoring"))
+	})
+
+	It("should apply the namespace policy to explicit namespaces", func() {
+		instance.Spec.NamespacePolicy = stablev1.NamespacePolicyOverride
+		u := object("v1", "ConfigMap", "monitoring")
+		Expect(r.setNamespace(instance, u)).To(Succeed())
+		Expect(u.GetNamespace()).To(Equal("apps"))
+
+		instance.Spec.NamespacePolicy = stablev1.NamespacePolicyForbid
+		err := r.setNamespace(instance, object("v1", "ConfigMap", "monitoring"))
+		Expect(failureReason(err)).To(Equal(reasonNamespaceForbidden))
+		Expect(r.setNamespace(instance, object("v1", "ConfigMap", "apps"))).To(Succeed())
+	})
+
+	It("should fail on kinds the cluster does not serve", func() {
+		err := r.setNamespace(instance, object("example.com/v1", "Widget", ""))
+		Expect(failureReason(err)).To(Equal(reasonUnknownKind))
+	})
+})
+
+var _ = Describe("ensureNamespace", func() {
+	var (
+		r        *ChartReconciler
+		instance *stablev1.Chart
+	)
+
+	BeforeEach(func() {
+		instance = &stablev1.Chart{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "stable.helm.operator.io/v1", Kind: "Chart"},
+			ObjectMeta: metav1.ObjectMeta{Name: "app"},
+			Spec: stablev1.ChartSpec{
+				NameSpaceSelector: "apps",
+				CreateNamespace:   true,
+				NamespaceMetadata: &stablev1.NamespaceMetadata{
+					Labels:      map[string]string{"team": "platform"},
+					Annotations: map[string]string{"owner": "platform@example.com"},
+				},
+			},
+		}
+		existing := &corev1.Namespace{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Namespace"},
+			ObjectMeta: metav1.ObjectMeta{Name: "existing"},
+		}
+		r = &ChartReconciler{
+			Client: fake.NewFakeClientWithScheme(testScheme(), instance.DeepCopy(), existing),
+			Log:    ctrl.Log.WithName("test"),
+		}
+	})
+
+	It("should create the namespace with its metadata and track it", func() {
+		Expect(r.ensureNamespace(instance)).To(Succeed())
+		Expect(instance.Status.CreatedNamespace).To(Equal("apps"))
+
+		ns := &corev1.Namespace{}
+		Expect(r.Get(ctx, types.NamespacedName{Name: "apps"}, ns)).To(Succeed())
+		Expect(ns.Labels).To(HaveKeyWithValue("team", "platform"))
+		Expect(ns.Annotations).To(HaveKeyWithValue("owner", "platform@example.com"))
+
+		instance.Spec.NamespaceMetadata.Labels["team"] = "apps"
+		Expect(r.ensureNamespace(instance)).To(Succeed())
+		Expect(r.Get(ctx, types.NamespacedName{Name: "apps"}, ns)).To(Succeed())
+		Expect(ns.Labels).To(HaveKeyWithValue("team", "apps"))
+	})
+
+	It("should leave existing namespaces alone", func() {
+		instance.Spec.NameSpaceSelector = "existing"
+		Expect(r.ensureNamespace(instance)).To(Succeed())
+		Expect(instance.Status.CreatedNamespace).To(BeEmpty())
+
+		ns := &corev1.Namespace{}
+		Expect(r.Get(ctx, types.NamespacedName{Name: "existing"}, ns)).To(Succeed())
+		Expect(ns.Labels).To(BeEmpty())
+	})
+
+	It("should only delete created namespaces with the Delete policy", func() {
+		Expect(r.ensureNamespace(instance)).To(Succeed())
+		Expect(r.deleteNamespace(instance)).To(Succeed())
+		ns := &corev1.Namespace{}
+		Expect(r.Get(ctx, types.NamespacedName{Name: "apps"}, ns)).To(Succeed())
+
+		instance.Spec.NamespaceDeletionPolicy = stablev1.NamespaceDeletionPolicyDelete
+		Expect(r.deleteNamespace(instance)).To(Succeed())
+		err := r.Get(ctx, types.NamespacedName{Name: "apps"}, ns)
+		Expect(apierrs.IsNotFound(err)).To(BeTrue())
+	})
+})
@@ -0,0 +1,145 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"fmt"
+
+	stablev1 "github.com/Spazzy757/helm-operator/api/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+// NamespacedCharts are reconciled as Charts with a namespace, they are
+// converted when read and converted back when written
+
+// Returns whether the instance is a NamespacedChart
+func isNamespaced(instance *stablev1.Chart) bool {
+	return instance.GetNamespace() != ""
+}
+
+// Converts a NamespacedChart to the Chart it is reconciled as, namespaces it
+// leaves out default to its own
+func fromNamespaced(nc *stablev1.NamespacedChart) *stablev1.Chart {
+	c := &stablev1.Chart{
+		ObjectMeta: nc.ObjectMeta,
+		Spec:       nc.Spec,
+		Status:     nc.Status,
+	}
+	if c.Spec.NameSpaceSelector == "" {
+		c.Spec.NameSpaceSelector = nc.Namespace
+	}
+	if ref := c.Spec.SecretRef; ref != nil && ref.Namespace == "" {
+		c.Spec.SecretRef = &corev1.SecretReference{Name: ref.Name, Namespace: nc.Namespace}
+	}
+	return c
+}
+
+// Converts the Chart a NamespacedChart is reconciled as back
+func toNamespaced(c *stablev1.Chart) *stablev1.NamespacedChart {
+	return &stablev1.NamespacedChart{
+		ObjectMeta: c.ObjectMeta,
+		Spec:       c.Spec,
+		Status:     c.Status,
+	}
+}
+
+// Fetches the Chart or, for namespaced requests, the NamespacedChart to
+// reconcile
+func (r *ChartReconciler) getInstance(key types.NamespacedName) (*stablev1.Chart, error) {
+	if key.Namespace == "" {
+		instance := &stablev1.Chart{}
+		return instance, r.Get(ctx, key, instance)
+	}
+	nc := &stablev1.NamespacedChart{}
+	if err := r.Get(ctx, key, nc); err != nil {
+		return nil, err
+	}
+	return fromNamespaced(nc), nil
+}
+
+// Updates the finalizers of the instance, the spec of NamespacedCharts is
+// left as it was written
+func (r *ChartReconciler) updateFinalizers(instance *stablev1.Chart) error {
+	if !isNamespaced(instance) {
+		return r.Update(ctx, instance)
+	}
+	nc := &stablev1.NamespacedChart{}
+	if err := r.Get(ctx, types.NamespacedName{Namespace: instance.GetNamespace(), Name: instance.GetName()}, nc); err != nil {
+		return err
+	}
+	nc.Finalizers = instance.Finalizers
+	if err := r.Update(ctx, nc); err != nil {
+		return err
+	}
+	instance.ResourceVersion = nc.ResourceVersion
+	return nil
+}
+
+// Sets the instance as the controller of a resource
+func setOwner(instance *stablev1.Chart, obj metav1.Object, scheme *runtime.Scheme) error {
+	if isNamespaced(instance) {
+		return ctrl.SetControllerReference(toNamespaced(instance), obj, scheme)
+	}
+	return ctrl.SetControllerReference(instance, obj, scheme)
+}
+
+// Returns every Chart and NamespacedChart, the latter converted to Charts
+func (r *ChartReconciler) listCharts() ([]stablev1.Chart, error) {
+	charts := &stablev1.ChartList{}
+	if err := r.List(ctx, charts); err != nil {
+		return nil, err
+	}
+	namespaced := &stablev1.NamespacedChartList{}
+	if err := r.List(ctx, namespaced); err != nil {
+		return nil, err
+	}
+	items := charts.Items
+	for i := range namespaced.Items {
+		items = append(items, *fromNamespaced(&namespaced.Items[i]))
+	}
+	return items, nil
+}
+
+// NamespacedCharts can only refer to their own namespace, which keeps their
+// authors from reaching into namespaces they have no access to
+func checkNamespaces(instance *stablev1.Chart) error {
+	if !isNamespaced(instance) {
+		return nil
+	}
+	own := instance.GetNamespace()
+	forbidden := func(what, namespace string) error {
+		return &reasonError{
+			reason: reasonNamespaceForbidden,
+			err:    fmt.Errorf("%s is in namespace %s, namespaced charts can only use %s", what, namespace, own),
+		}
+	}
+	if ns := instance.Spec.NameSpaceSelector; ns != own {
+		return forbidden("nameSpaceSelector", ns)
+	}
+	if ref := instance.Spec.SecretRef; ref != nil && ref.Namespace != own {
+		return forbidden("secretRef", ref.Namespace)
+	}
+	for _, ref := range instance.Spec.ValuesFrom {
+		if ref.Namespace != "" && ref.Namespace != own {
+			return forbidden(fmt.Sprintf("%s %s", ref.Kind, ref.Name), ref.Namespace)
+		}
+	}
+	return nil
+}
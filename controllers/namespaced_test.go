@@ -0,0 +1,120 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	stablev1 "github.com/Spazzy757/helm-operator/api/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var _ = Describe("namespaced charts", func() {
+	var (
+		r  *ChartReconciler
+		nc *stablev1.NamespacedChart
+	)
+
+	BeforeEach(func() {
+		nc = &stablev1.NamespacedChart{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "stable.helm.operator.io/v1", Kind: "NamespacedChart"},
+			ObjectMeta: metav1.ObjectMeta{Name: "foo", Namespace: "team-a", UID: "1234"},
+			Spec: stablev1.ChartSpec{
+				Chart:     "nginx",
+				SecretRef: &corev1.SecretReference{Name: "credentials"},
+			},
+		}
+		r = &ChartReconciler{
+			Client: fake.NewFakeClientWithScheme(testScheme(), nc,
+				&stablev1.Chart{
+					TypeMeta:   metav1.TypeMeta{APIVersion: "stable.helm.operator.io/v1", Kind: "Chart"},
+					ObjectMeta: metav1.ObjectMeta{Name: "bar"},
+				},
+			),
+			Log:    ctrl.Log.WithName("test"),
+			Scheme: testScheme(),
+			Mapper: testMapper(),
+		}
+	})
+
+	It("should be reconciled as a chart confined to its namespace", func() {
+		instance, err := r.getInstance(types.NamespacedName{Namespace: "team-a", Name: "foo"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(isNamespaced(instance)).To(BeTrue())
+		Expect(instance.Spec.NameSpaceSelector).To(Equal("team-a"))
+		Expect(instance.Spec.SecretRef.Namespace).To(Equal("team-a"))
+		Expect(nc.Spec.SecretRef.Namespace).To(BeEmpty())
+		Expect(checkNamespaces(instance)).To(Succeed())
+		Expect(revisionSecretName(instance, 1)).To(Equal("helm-operator.namespaced.foo.v1"))
+
+		instance.Spec.ValuesFrom = []stablev1.ValuesReference{{Kind: "Secret", Name: "db", Namespace: "team-b"}}
+		Expect(failureReason(checkNamespaces(instance))).To(Equal(reasonNamespaceForbidden))
+		instance.Spec.ValuesFrom = nil
+		instance.Spec.NameSpaceSelector = "kube-system"
+		Expect(failureReason(checkNamespaces(instance))).To(Equal(reasonNamespaceForbidden))
+	})
+
+	It("should not deploy outside of its namespace", func() {
+		instance := fromNamespaced(nc)
+		object := func(apiVersion, kind, namespace string) *unstructured.Unstructured {
+			u := &unstructured.Unstructured{}
+			u.SetAPIVersion(apiVersion)
+			u.SetKind(kind)
+			u.SetName("foo")
+			u.SetNamespace(namespace)
+			return u
+		}
+		Expect(r.setNamespace(instance, object("v1", "ConfigMap", ""))).To(Succeed())
+		err := r.setNamespace(instance, object("v1", "ConfigMap", "team-b"))
+		Expect(failureReason(err)).To(Equal(reasonNamespaceForbidden))
+		err = r.setNamespace(instance, object("rbac.authorization.k8s.io/v1", "ClusterRole", ""))
+		Expect(failureReason(err)).To(Equal(reasonNamespaceForbidden))
+
+		instance.Spec.NamespacePolicy = stablev1.NamespacePolicyOverride
+		u := object("v1", "ConfigMap", "team-b")
+		Expect(r.setNamespace(instance, u)).To(Succeed())
+		Expect(u.GetNamespace()).To(Equal("team-a"))
+	})
+
+	It("should be written back as a NamespacedChart", func() {
+		instance := fromNamespaced(nc)
+		instance.Status.LastAttemptedRevision = "1.0.0"
+		Expect(r.UpdateStatus(instance)).To(Succeed())
+		instance.Finalizers = []string{"helm.operator.finalizer.io"}
+		Expect(r.updateFinalizers(instance)).To(Succeed())
+
+		fetched := &stablev1.NamespacedChart{}
+		Expect(r.Get(ctx, types.NamespacedName{Namespace: "team-a", Name: "foo"}, fetched)).To(Succeed())
+		Expect(fetched.Status.LastAttemptedRevision).To(Equal("1.0.0"))
+		Expect(fetched.Finalizers).To(ConsistOf("helm.operator.finalizer.io"))
+
+		cm := &corev1.ConfigMap{}
+		Expect(setOwner(instance, cm, r.Scheme)).To(Succeed())
+		Expect(cm.OwnerReferences[0].Kind).To(Equal("NamespacedChart"))
+	})
+
+	It("should list both kinds of charts", func() {
+		charts, err := r.listCharts()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(charts).To(HaveLen(2))
+	})
+})
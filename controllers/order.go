@@ -0,0 +1,138 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"sort"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// Order helm installs kinds in, so dependencies exist before what uses them
+var installOrder = []string{
+	"Namespace",
+	"ResourceQuota",
+	"LimitRange",
+	"PodSecurityPolicy",
+	"PodDisruptionBudget",
+	"Secret",
+	"ConfigMap",
+	"StorageClass",
+	"PersistentVolume",
+	"PersistentVolumeClaim",
+	"ServiceAccount",
+	"CustomResourceDefinition",
+	"ClusterRole",
+	"ClusterRoleList",
+	"ClusterRoleBinding",
+	"ClusterRoleBindingList",
+	"Role",
+	"RoleList",
+	"RoleBinding",
+	"RoleBindingList",
+	"Service",
+	"DaemonSet",
+	"Pod",
+	"ReplicationController",
+	"ReplicaSet",
+	"Deployment",
+	"HorizontalPodAutoscaler",
+	"StatefulSet",
+	"Job",
+	"CronJob",
+	"Ingress",
+	"APIService",
+}
+
+// Order helm deletes kinds in, services go early to stop traffic before the
+// workloads behind them are removed
+var uninstallOrder = []string{
+	"APIService",
+	"Ingress",
+	"Service",
+	"CronJob",
+	"Job",
+	"StatefulSet",
+	"HorizontalPodAutoscaler",
+	"Deployment",
+	"ReplicaSet",
+	"ReplicationController",
+	"Pod",
+	"DaemonSet",
+	"RoleBindingList",
+	"RoleBinding",
+	"RoleList",
+	"Role",
+	"ClusterRoleBindingList",
+	"ClusterRoleBinding",
+	"ClusterRoleList",
+	"ClusterRole",
+	"CustomResourceDefinition",
+	"ServiceAccount",
+	"PersistentVolumeClaim",
+	"PersistentVolume",
+	"StorageClass",
+	"ConfigMap",
+	"Secret",
+	"PodDisruptionBudget",
+	"PodSecurityPolicy",
+	"LimitRange",
+	"ResourceQuota",
+	"Namespace",
+}
+
+// Returns a less function over kinds for an order, kinds missing from the
+// order are installed after and deleted before all known kinds, sorted by name
+func kindLess(order []string, install bool) func(a, b string) bool {
+	rank := make(map[string]int, len(order))
+	for i, kind := range order {
+		rank[kind] = i
+	}
+	return func(a, b string) bool {
+		ra, aok := rank[a]
+		rb, bok := rank[b]
+		switch {
+		case aok && bok:
+			return ra < rb
+		case !aok && !bok:
+			return a < b
+		case install:
+			return aok
+		default:
+			return bok
+		}
+	}
+}
+
+// Sorts rendered objects in install order, objects of the same kind keep
+// the order they were rendered in
+func sortForInstall(objects []*unstructured.Unstructured) {
+	less := kindLess(installOrder, true)
+	sort.SliceStable(objects, func(i, j int) bool {
+		return less(objects[i].GetKind(), objects[j].GetKind())
+	})
+}
+
+// Returns a copy of the references in uninstall order
+func sortForUninstall(refs []corev1.ObjectReference) []corev1.ObjectReference {
+	sorted := append([]corev1.ObjectReference(nil), refs...)
+	less := kindLess(uninstallOrder, false)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return less(sorted[i].Kind, sorted[j].Kind)
+	})
+	return sorted
+}
@@ -0,0 +1,57 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+var _ = Describe("install order", func() {
+	It("should install dependencies before workloads", func() {
+		var objects []*unstructured.Unstructured
+		for _, kind := range []string{"Deployment", "Widget", "Service", "ConfigMap", "Gadget", "ServiceAccount", "Namespace", "ConfigMap"} {
+			u := &unstructured.Unstructured{}
+			u.SetKind(kind)
+			u.SetName(kind + string(rune('0'+len(objects))))
+			objects = append(objects, u)
+		}
+		sortForInstall(objects)
+
+		var names []string
+		for _, u := range objects {
+			names = append(names, u.GetName())
+		}
+		Expect(names).To(Equal([]string{
+			"Namespace6", "ConfigMap3", "ConfigMap7", "ServiceAccount5", "Service2", "Deployment0", "Gadget4", "Widget1",
+		}))
+	})
+
+	It("should delete in uninstall order", func() {
+		refs := []corev1.ObjectReference{
+			{Kind: "Namespace"}, {Kind: "ConfigMap"}, {Kind: "Widget"}, {Kind: "Deployment"}, {Kind: "Service"},
+		}
+		var kinds []string
+		for _, ref := range sortForUninstall(refs) {
+			kinds = append(kinds, ref.Kind)
+		}
+		Expect(kinds).To(Equal([]string{"Widget", "Service", "Deployment", "ConfigMap", "Namespace"}))
+		Expect(refs[0].Kind).To(Equal("Namespace"))
+	})
+})
@@ -0,0 +1,146 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"encoding/json"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/jsonmergepatch"
+	"k8s.io/apimachinery/pkg/util/mergepatch"
+	"k8s.io/apimachinery/pkg/util/strategicpatch"
+)
+
+// Annotation holding the manifest the operator last rendered for a resource,
+// used as the "original" side of the three-way merge on update
+const lastAppliedAnnotation = "helm.operator.io/last-applied-configuration"
+
+// Replaces the values of Secrets in patches reported in the status
+const redactedValue = "REDACTED"
+
+// Stores the rendered manifest of the resource in its last applied annotation
+func setLastApplied(u *unstructured.Unstructured) error {
+	annotations := u.GetAnnotations()
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	delete(annotations, lastAppliedAnnotation)
+	if len(annotations) == 0 {
+		u.SetAnnotations(nil)
+	} else {
+		u.SetAnnotations(annotations)
+	}
+	manifest, err := u.MarshalJSON()
+	if err != nil {
+		return err
+	}
+	annotations[lastAppliedAnnotation] = string(manifest)
+	u.SetAnnotations(annotations)
+	return nil
+}
+
+// Returns the last applied manifest stored on a live resource, if any
+func getLastApplied(u *unstructured.Unstructured) []byte {
+	if manifest, ok := u.GetAnnotations()[lastAppliedAnnotation]; ok {
+		return []byte(manifest)
+	}
+	return nil
+}
+
+// Computes the patch that moves the live resource to the newly rendered one
+// while leaving fields the chart never set (e.g. replicas owned by an HPA)
+// untouched. Kinds known to the scheme get a strategic merge patch so lists
+// keyed by name (containers, ports, env) are merged, anything else (CRDs)
+// falls back to a JSON merge patch.
+func threeWayMergePatch(scheme *runtime.Scheme, live, rendered *unstructured.Unstructured) (types.PatchType, []byte, error) {
+	original := getLastApplied(live)
+	modified, err := rendered.MarshalJSON()
+	if err != nil {
+		return "", nil, err
+	}
+	current, err := live.MarshalJSON()
+	if err != nil {
+		return "", nil, err
+	}
+	preconditions := []mergepatch.PreconditionFunc{
+		mergepatch.RequireKeyUnchanged("apiVersion"),
+		mergepatch.RequireKeyUnchanged("kind"),
+		mergepatch.RequireMetadataKeyUnchanged("name"),
+	}
+
+	versioned, err := scheme.New(rendered.GroupVersionKind())
+	if err != nil {
+		if !runtime.IsNotRegisteredError(err) {
+			return "", nil, err
+		}
+		patch, err := jsonmergepatch.CreateThreeWayJSONMergePatch(original, modified, current, preconditions...)
+		return types.MergePatchType, patch, err
+	}
+	meta, err := strategicpatch.NewPatchMetaFromStruct(versioned)
+	if err != nil {
+		return "", nil, err
+	}
+	patch, err := strategicpatch.CreateThreeWayMergePatch(original, modified, current, meta, true, preconditions...)
+	return types.StrategicMergePatchType, patch, err
+}
+
+// Checks if a patch would not change anything
+func isEmptyPatch(patch []byte) bool {
+	var m map[string]interface{}
+	if err := json.Unmarshal(patch, &m); err != nil {
+		return false
+	}
+	return len(m) == 0
+}
+
+// Returns a patch fit to be reported in the status of a chart: the last
+// applied annotation, which holds the whole manifest, is left out and the
+// values of a Secret are redacted while keeping the keys they change
+func redactPatch(gvk schema.GroupVersionKind, patch []byte) []byte {
+	var m map[string]interface{}
+	if patch == nil || json.Unmarshal(patch, &m) != nil {
+		return patch
+	}
+	unstructured.RemoveNestedField(m, "metadata", "annotations", lastAppliedAnnotation)
+	if annotations, found, _ := unstructured.NestedMap(m, "metadata", "annotations"); found && len(annotations) == 0 {
+		unstructured.RemoveNestedField(m, "metadata", "annotations")
+	}
+	if metadata, found, _ := unstructured.NestedMap(m, "metadata"); found && len(metadata) == 0 {
+		delete(m, "metadata")
+	}
+	if gvk.Group == "" && gvk.Kind == "Secret" {
+		for _, field := range []string{"data", "stringData"} {
+			values, ok := m[field].(map[string]interface{})
+			if !ok {
+				continue
+			}
+			for k, v := range values {
+				// removed keys stay null
+				if v != nil {
+					values[k] = redactedValue
+				}
+			}
+		}
+	}
+	redacted, err := json.Marshal(m)
+	if err != nil {
+		return patch
+	}
+	return redacted
+}
@@ -0,0 +1,120 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"encoding/json"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+)
+
+func deployment(image string, replicas int64) *unstructured.Unstructured {
+	u := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "apps/v1",
+		"kind":       "Deployment",
+		"metadata": map[string]interface{}{
+			"name":      "foo",
+			"namespace": "default",
+		},
+		"spec": map[string]interface{}{
+			"template": map[string]interface{}{
+				"spec": map[string]interface{}{
+					"containers": []interface{}{
+						map[string]interface{}{"name": "foo", "image": image},
+					},
+				},
+			},
+		},
+	}}
+	if replicas > 0 {
+		Expect(unstructured.SetNestedField(u.Object, replicas, "spec", "replicas")).To(Succeed())
+	}
+	return u
+}
+
+var _ = Describe("threeWayMergePatch", func() {
+
+	It("should only patch fields owned by the chart", func() {
+		applied := deployment("nginx:1.15", 0)
+		Expect(setLastApplied(applied)).To(Succeed())
+
+		// an autoscaler scaled the live deployment
+		live := applied.DeepCopy()
+		Expect(unstructured.SetNestedField(live.Object, int64(5), "spec", "replicas")).To(Succeed())
+
+		rendered := deployment("nginx:1.16", 0)
+		Expect(setLastApplied(rendered)).To(Succeed())
+
+		patchType, patch, err := threeWayMergePatch(scheme.Scheme, live, rendered)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(patchType).To(Equal(types.StrategicMergePatchType))
+		Expect(string(patch)).To(ContainSubstring("nginx:1.16"))
+		Expect(string(patch)).NotTo(ContainSubstring("replicas"))
+	})
+
+	It("should produce an empty patch when nothing changed", func() {
+		rendered := deployment("nginx:1.15", 2)
+		Expect(setLastApplied(rendered)).To(Succeed())
+		live := rendered.DeepCopy()
+
+		_, patch, err := threeWayMergePatch(scheme.Scheme, live, rendered)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(isEmptyPatch(patch)).To(BeTrue())
+	})
+
+	It("should remove fields the chart stopped rendering", func() {
+		applied := deployment("nginx:1.15", 3)
+		Expect(setLastApplied(applied)).To(Succeed())
+		live := applied.DeepCopy()
+
+		rendered := deployment("nginx:1.15", 0)
+		Expect(setLastApplied(rendered)).To(Succeed())
+
+		_, patch, err := threeWayMergePatch(scheme.Scheme, live, rendered)
+		Expect(err).NotTo(HaveOccurred())
+		m := map[string]interface{}{}
+		Expect(json.Unmarshal(patch, &m)).To(Succeed())
+		replicas, found, err := unstructured.NestedFieldNoCopy(m, "spec", "replicas")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(found).To(BeTrue())
+		Expect(replicas).To(BeNil())
+	})
+
+	It("should fall back to a JSON merge patch for unknown kinds", func() {
+		applied := &unstructured.Unstructured{Object: map[string]interface{}{
+			"apiVersion": "example.com/v1",
+			"kind":       "Widget",
+			"metadata":   map[string]interface{}{"name": "foo"},
+			"spec":       map[string]interface{}{"size": "small"},
+		}}
+		Expect(setLastApplied(applied)).To(Succeed())
+		live := applied.DeepCopy()
+
+		rendered := applied.DeepCopy()
+		Expect(unstructured.SetNestedField(rendered.Object, "large", "spec", "size")).To(Succeed())
+		Expect(setLastApplied(rendered)).To(Succeed())
+
+		patchType, patch, err := threeWayMergePatch(scheme.Scheme, live, rendered)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(patchType).To(Equal(types.MergePatchType))
+		Expect(string(patch)).To(ContainSubstring(`"size":"large"`))
+	})
+})
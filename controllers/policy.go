@@ -0,0 +1,240 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/Masterminds/semver"
+	stablev1 "github.com/Spazzy757/helm-operator/api/v1"
+	"github.com/Spazzy757/helm-operator/repository"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+)
+
+const (
+	// The chart or its rendered resources are not allowed by a ChartPolicy
+	reasonPolicyViolation = "PolicyViolation"
+)
+
+// What a chart would install, as checked against policies
+type policySubject struct {
+	repository string
+	chart      string
+	version    string
+	namespaces []string
+	kinds      []schema.GroupKind
+}
+
+// Checks the release about to be deployed, a stored revision when rolled
+// back, against every ChartPolicy that applies to it before anything of the
+// release is applied. The chart and version are those the release was
+// rendered from
+func (r *ChartReconciler) checkPolicies(instance *stablev1.Chart, rel *release) error {
+	policies := &stablev1.ChartPolicyList{}
+	if err := r.List(ctx, policies); err != nil {
+		return err
+	}
+	if len(policies.Items) == 0 {
+		return nil
+	}
+	subject := &policySubject{
+		repository: r.chartRepositoryURL(instance),
+		chart:      rel.chart,
+		version:    rel.chartVersion,
+	}
+	var err error
+	subject.namespaces, subject.kinds, err = manifestScope(instance, rel.manifest)
+	if err != nil {
+		return err
+	}
+
+	var violations []string
+	for _, policy := range policies.Items {
+		applies, err := policyApplies(&policy, instance)
+		if err != nil {
+			violations = append(violations, fmt.Sprintf("policy %s: %v", policy.Name, err))
+			continue
+		}
+		if !applies {
+			continue
+		}
+		for _, v := range policyViolations(&policy.Spec, subject) {
+			violations = append(violations, fmt.Sprintf("policy %s: %s", policy.Name, v))
+		}
+	}
+	if len(violations) > 0 {
+		return &reasonError{reason: reasonPolicyViolation, err: errors.New(strings.Join(violations, "; "))}
+	}
+	return nil
+}
+
+// Returns the URL the chart is fetched from, checked against the
+// repositories of policies
+func (r *ChartReconciler) chartRepositoryURL(instance *stablev1.Chart) string {
+	if src := instance.Spec.Source; src != nil && src.Git != nil {
+		return src.Git.URL
+	}
+	if ref := instance.Spec.RepositoryRef; ref != nil {
+		if r.RepositoryCache != nil {
+			if entry, ok := r.RepositoryCache.Get(ref.Name); ok {
+				return entry.URL
+			}
+		}
+		return ""
+	}
+	return repository.ResolveURL(instance.Spec.Repo)
+}
+
+// Returns whether the chart selector of a policy selects the chart
+func policyApplies(policy *stablev1.ChartPolicy, instance *stablev1.Chart) (bool, error) {
+	if policy.Spec.ChartSelector == nil {
+		return true, nil
+	}
+	selector, err := metav1.LabelSelectorAsSelector(policy.Spec.ChartSelector)
+	if err != nil {
+		return false, fmt.Errorf("invalid chartSelector: %v", err)
+	}
+	return selector.Matches(labels.Set(instance.GetLabels())), nil
+}
+
+// Returns the namespaces and kinds of a rendered manifest, hooks and CRDs
+// included. Resources without a namespace go to nameSpaceSelector
+func manifestScope(instance *stablev1.Chart, manifest []byte) ([]string, []schema.GroupKind, error) {
+	namespaces := map[string]bool{}
+	if instance.Spec.NameSpaceSelector != "" {
+		namespaces[instance.Spec.NameSpaceSelector] = true
+	}
+	seen := map[schema.GroupKind]bool{}
+	var kinds []schema.GroupKind
+	objects, err := decodeManifest(manifest)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, u := range objects {
+		if ns := u.GetNamespace(); ns != "" && instance.Spec.NamespacePolicy != stablev1.NamespacePolicyOverride {
+			namespaces[ns] = true
+		}
+		gvk := u.GroupVersionKind()
+		gk := gvk.GroupKind()
+		if !seen[gk] {
+			seen[gk] = true
+			kinds = append(kinds, gk)
+		}
+	}
+	var list []string
+	for ns := range namespaces {
+		list = append(list, ns)
+	}
+	sort.Strings(list)
+	return list, kinds, nil
+}
+
+// Returns what a policy does not allow of the subject
+func policyViolations(spec *stablev1.ChartPolicySpec, subject *policySubject) []string {
+	var violations []string
+	if len(spec.Repositories) > 0 && !matchAny(spec.Repositories, subject.repository) {
+		violations = append(violations, fmt.Sprintf("repository %q is not allowed", subject.repository))
+	}
+	if len(spec.Charts) > 0 && !chartAllowed(spec.Charts, subject.chart, subject.version) {
+		violations = append(violations, fmt.Sprintf("chart %s version %s is not allowed", subject.chart, subject.version))
+	}
+	if len(spec.Namespaces) > 0 {
+		for _, ns := range subject.namespaces {
+			if !matchAny(spec.Namespaces, ns) {
+				violations = append(violations, fmt.Sprintf("namespace %s is not allowed", ns))
+			}
+		}
+	}
+	for _, gk := range subject.kinds {
+		if len(spec.AllowedKinds) > 0 && !matchKind(spec.AllowedKinds, gk) {
+			violations = append(violations, fmt.Sprintf("kind %s is not allowed", gk))
+		} else if matchKind(spec.DeniedKinds, gk) {
+			violations = append(violations, fmt.Sprintf("kind %s is denied", gk))
+		}
+	}
+	return violations
+}
+
+// Returns whether a chart version is allowed by any of the rules, rules
+// with an invalid range allow nothing
+func chartAllowed(rules []stablev1.ChartRule, name, version string) bool {
+	for _, rule := range rules {
+		if !matchAny([]string{rule.Name}, name) {
+			continue
+		}
+		if rule.Versions == "" {
+			return true
+		}
+		// ranges are read like the version of a chart
+		constraint, err := repository.ParseRange(rule.Versions)
+		if err != nil {
+			continue
+		}
+		v, err := semver.NewVersion(version)
+		if err != nil {
+			continue
+		}
+		if constraint.Check(v) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns whether a group kind is matched by any of the rules
+func matchKind(rules []stablev1.KindRule, gk schema.GroupKind) bool {
+	for _, rule := range rules {
+		if (rule.APIGroup == "*" || rule.APIGroup == gk.Group) && matchAny([]string{rule.Kind}, gk.Kind) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns whether s is matched by any of the glob patterns
+func matchAny(patterns []string, s string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, s); ok || pattern == s {
+			return true
+		}
+	}
+	return false
+}
+
+// Maps a ChartPolicy to every chart so they are checked against it again
+func (r *ChartReconciler) chartsForPolicy(o handler.MapObject) []ctrl.Request {
+	charts, err := r.listCharts()
+	if err != nil {
+		r.Log.Error(err, "unable to list charts", "policy", o.Meta.GetName())
+		return nil
+	}
+	var requests []ctrl.Request
+	for _, c := range charts {
+		requests = append(requests, ctrl.Request{
+			NamespacedName: types.NamespacedName{Namespace: c.GetNamespace(), Name: c.GetName()},
+		})
+	}
+	return requests
+}
@@ -0,0 +1,146 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"fmt"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	stablev1 "github.com/Spazzy757/helm-operator/api/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var _ = Describe("chart policies", func() {
+	var instance *stablev1.Chart
+
+	rel := &release{manifest: []byte(`
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: config
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRoleBinding
+metadata:
+  name: admin
+---
+apiVersion: batch/v1
+kind: Job
+metadata:
+  name: migrate
+  namespace: tools
+`), chart: "mychart", chartVersion: "0.1.0"}
+
+	reconciler := func(policies ...stablev1.ChartPolicySpec) *ChartReconciler {
+		objs := []runtime.Object{}
+		for i, spec := range policies {
+			objs = append(objs, &stablev1.ChartPolicy{
+				TypeMeta:   metav1.TypeMeta{APIVersion: "stable.helm.operator.io/v1", Kind: "ChartPolicy"},
+				ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprintf("policy-%d", i)},
+				Spec:       spec,
+			})
+		}
+		return &ChartReconciler{
+			Client: fake.NewFakeClientWithScheme(testScheme(), objs...),
+			Log:    ctrl.Log.WithName("test"),
+			Scheme: testScheme(),
+		}
+	}
+
+	BeforeEach(func() {
+		instance = &stablev1.Chart{
+			ObjectMeta: metav1.ObjectMeta{Name: "foo", Labels: map[string]string{"team": "a"}},
+			Spec:       stablev1.ChartSpec{Chart: "mychart", NameSpaceSelector: "default"},
+		}
+	})
+
+	It("should allow charts without policies", func() {
+		Expect(reconciler().checkPolicies(instance, rel)).To(Succeed())
+	})
+
+	It("should allow charts complying with every policy", func() {
+		r := reconciler(
+			stablev1.ChartPolicySpec{
+				Repositories: []string{"https://kubernetes-charts.storage.googleapis.com"},
+				Charts:       []stablev1.ChartRule{{Name: "my*", Versions: ">=0.1.0, <1.0.0"}},
+				Namespaces:   []string{"default", "tools"},
+			},
+			stablev1.ChartPolicySpec{
+				DeniedKinds: []stablev1.KindRule{{APIGroup: "*", Kind: "PodSecurityPolicy"}},
+			},
+		)
+		Expect(r.checkPolicies(instance, rel)).To(Succeed())
+	})
+
+	It("should report every violation before anything is applied", func() {
+		r := reconciler(
+			stablev1.ChartPolicySpec{
+				Repositories: []string{"https://charts.example.com/*"},
+				Charts:       []stablev1.ChartRule{{Name: "mychart", Versions: "^1.0.0"}},
+				Namespaces:   []string{"default"},
+			},
+			stablev1.ChartPolicySpec{
+				DeniedKinds: []stablev1.KindRule{{APIGroup: "rbac.authorization.k8s.io", Kind: "ClusterRoleBinding"}},
+			},
+		)
+		err := r.checkPolicies(instance, rel)
+		Expect(failureReason(err)).To(Equal(reasonPolicyViolation))
+		Expect(err.Error()).To(ContainSubstring(`policy policy-0: repository "https://kubernetes-charts.storage.googleapis.com" is not allowed`))
+		Expect(err.Error()).To(ContainSubstring("policy policy-0: chart mychart version 0.1.0 is not allowed"))
+		Expect(err.Error()).To(ContainSubstring("policy policy-0: namespace tools is not allowed"))
+		Expect(err.Error()).To(ContainSubstring("policy policy-1: kind ClusterRoleBinding.rbac.authorization.k8s.io is denied"))
+		Expect(stalledReasons[reasonPolicyViolation]).To(BeTrue())
+	})
+
+	It("should check the chart a rolled back release was rendered from", func() {
+		r := reconciler(stablev1.ChartPolicySpec{Charts: []stablev1.ChartRule{{Name: "mychart", Versions: ">=0.1 <1"}}})
+		Expect(r.checkPolicies(instance, rel)).To(Succeed())
+
+		rollback := *rel
+		rollback.chartVersion = "0.0.9"
+		err := r.checkPolicies(instance, &rollback)
+		Expect(err).To(MatchError("policy policy-0: chart mychart version 0.0.9 is not allowed"))
+	})
+
+	It("should only allow the listed kinds", func() {
+		r := reconciler(stablev1.ChartPolicySpec{
+			AllowedKinds: []stablev1.KindRule{{Kind: "ConfigMap"}, {APIGroup: "batch", Kind: "*"}},
+		})
+		err := r.checkPolicies(instance, rel)
+		Expect(err).To(MatchError("policy policy-0: kind ClusterRoleBinding.rbac.authorization.k8s.io is not allowed"))
+	})
+
+	It("should only apply policies selecting the chart", func() {
+		r := reconciler(stablev1.ChartPolicySpec{
+			ChartSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"team": "b"}},
+			Namespaces:    []string{"team-b"},
+		})
+		Expect(r.checkPolicies(instance, rel)).To(Succeed())
+		instance.Labels["team"] = "b"
+		Expect(failureReason(r.checkPolicies(instance, rel))).To(Equal(reasonPolicyViolation))
+	})
+
+	It("should check git sources by their URL", func() {
+		instance.Spec.Source = &stablev1.ChartSource{Git: &stablev1.GitSource{URL: "https://github.com/example/charts.git"}}
+		r := reconciler(stablev1.ChartPolicySpec{Repositories: []string{"https://github.com/example/*"}})
+		Expect(r.checkPolicies(instance, rel)).To(Succeed())
+	})
+})
@@ -0,0 +1,204 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	stablev1 "github.com/Spazzy757/helm-operator/api/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrs "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	ref "k8s.io/client-go/tools/reference"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+const (
+	// The chart is rendered and previewed but not applied
+	reasonApplySuspended = "ApplySuspended"
+)
+
+// Previews the changes applying the release would make in the status of the
+// instance, without changing the cluster
+func (r *ChartReconciler) previewRelease(instance *stablev1.Chart, rel *release) (ctrl.Result, error) {
+	changes, err := r.diffRelease(instance, rel)
+	if err != nil {
+		return r.failed(instance, stablev1.ChartApplied, err)
+	}
+	// an unchanged preview keeps its time, so the status is left as it was
+	// rather than written and reconciled again on every reconcile
+	diff := instance.Status.Diff
+	if diff == nil || diff.Revision != rel.chartRevision || diff.Digest != rel.digest ||
+		!equality.Semantic.DeepEqual(diff.Changes, changes) {
+		instance.Status.Diff = &stablev1.ChartDiff{
+			Revision: rel.chartRevision,
+			Digest:   rel.digest,
+			Time:     metav1.Now(),
+			Changes:  changes,
+		}
+	}
+	counts := map[stablev1.ChangeAction]int{}
+	for _, c := range changes {
+		counts[c.Action]++
+	}
+	stablev1.SetCondition(&instance.Status.Conditions, stablev1.Condition{
+		Type:   stablev1.ChartApplied,
+		Status: corev1.ConditionFalse,
+		Reason: reasonApplySuspended,
+		Message: fmt.Sprintf("Apply suspended, %d to create, %d to update and %d to delete",
+			counts[stablev1.ChangeCreate], counts[stablev1.ChangeUpdate], counts[stablev1.ChangeDelete]),
+	})
+	instance.Status.ObservedGeneration = instance.GetGeneration()
+	return ctrl.Result{}, r.UpdateStatus(instance)
+}
+
+// Compares the resources of the release with the live ones, in the order
+// they would be applied followed by the resources that would be pruned.
+// Hooks are left out
+func (r *ChartReconciler) diffRelease(instance *stablev1.Chart, rel *release) ([]stablev1.ResourceChange, error) {
+	var changes []stablev1.ResourceChange
+	if name := instance.Spec.NameSpaceSelector; instance.Spec.CreateNamespace && name != "" {
+		err := r.Get(ctx, types.NamespacedName{Name: name}, &corev1.Namespace{})
+		if apierrs.IsNotFound(err) {
+			changes = append(changes, stablev1.ResourceChange{APIVersion: "v1", Kind: "Namespace", Name: name, Action: stablev1.ChangeCreate})
+		} else if err != nil {
+			return nil, err
+		}
+	}
+
+	objects, err := decodeManifest(rel.manifest)
+	if err != nil {
+		return nil, err
+	}
+	sortForInstall(objects)
+	_, objects = splitHooks(objects)
+	var rendered []corev1.ObjectReference
+	for _, u := range objects {
+		crd := isCRD(u)
+		if crd && instance.Spec.CRDPolicy == stablev1.CRDPolicySkip {
+			continue
+		}
+		if !crd {
+			if err := setOwner(instance, u, r.Scheme); err != nil {
+				return nil, err
+			}
+		}
+		if err := r.setNamespace(instance, u); err != nil {
+			if failureReason(err) != reasonUnknownKind {
+				return nil, err
+			}
+			// kinds defined by CRDs of the chart are not known before they are created
+			if u.GetNamespace() == "" {
+				u.SetNamespace(instance.Spec.NameSpaceSelector)
+			}
+			changes = append(changes, resourceChange(u, stablev1.ChangeCreate, nil))
+			continue
+		}
+		if err := setLastApplied(u); err != nil {
+			return nil, err
+		}
+		if objRef, err := ref.GetReference(r.Scheme, u); err == nil && !crd {
+			rendered = append(rendered, *objRef)
+		}
+
+		live := &unstructured.Unstructured{}
+		live.SetGroupVersionKind(u.GroupVersionKind())
+		if err := r.Get(ctx, types.NamespacedName{Namespace: u.GetNamespace(), Name: u.GetName()}, live); err != nil {
+			if !apierrs.IsNotFound(err) {
+				return nil, err
+			}
+			changes = append(changes, resourceChange(u, stablev1.ChangeCreate, nil))
+			continue
+		}
+		if crd && instance.Spec.CRDPolicy != stablev1.CRDPolicyUpdate {
+			continue
+		}
+		if err := keepIgnoredFields(ignoredPaths(instance, u), live, u); err != nil {
+			return nil, err
+		}
+		_, patch, err := threeWayMergePatch(r.Scheme, live, u)
+		if err != nil {
+			return nil, err
+		}
+		if !isEmptyPatch(patch) {
+			changes = append(changes, resourceChange(u, stablev1.ChangeUpdate, patch))
+		}
+	}
+
+	for _, resource := range sortForUninstall(instance.Status.Resource) {
+		if !refInSlice(resource, rendered) {
+			changes = append(changes, stablev1.ResourceChange{
+				APIVersion: resource.APIVersion,
+				Kind:       resource.Kind,
+				Name:       resource.Name,
+				Namespace:  resource.Namespace,
+				Action:     stablev1.ChangeDelete,
+			})
+		}
+	}
+	return changes, nil
+}
+
+// Returns the change to a rendered resource, updates list the fields their
+// patch changes
+func resourceChange(u *unstructured.Unstructured, action stablev1.ChangeAction, patch []byte) stablev1.ResourceChange {
+	change := stablev1.ResourceChange{
+		APIVersion: u.GetAPIVersion(),
+		Kind:       u.GetKind(),
+		Name:       u.GetName(),
+		Namespace:  u.GetNamespace(),
+		Action:     action,
+	}
+	if patch != nil {
+		patch = redactPatch(u.GroupVersionKind(), patch)
+		change.Patch = string(patch)
+		change.Fields = patchFields(patch)
+	}
+	return change
+}
+
+// Returns the JSON pointers of the fields a patch changes, lists are changed
+// as a whole and strategic merge directives are left out
+func patchFields(patch []byte) []string {
+	var m map[string]interface{}
+	if err := json.Unmarshal(patch, &m); err != nil {
+		return nil
+	}
+	var fields []string
+	var walk func(prefix string, m map[string]interface{})
+	walk = func(prefix string, m map[string]interface{}) {
+		for k, v := range m {
+			if strings.HasPrefix(k, "$") {
+				continue
+			}
+			pointer := prefix + "/" + strings.Replace(strings.Replace(k, "~", "~0", -1), "/", "~1", -1)
+			if nested, ok := v.(map[string]interface{}); ok && len(nested) > 0 {
+				walk(pointer, nested)
+				continue
+			}
+			fields = append(fields, pointer)
+		}
+	}
+	walk("", m)
+	sort.Strings(fields)
+	return fields
+}
@@ -0,0 +1,157 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	stablev1 "github.com/Spazzy757/helm-operator/api/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrs "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var _ = Describe("preview", func() {
+	var (
+		r        *ChartReconciler
+		instance *stablev1.Chart
+	)
+
+	BeforeEach(func() {
+		instance = &stablev1.Chart{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "stable.helm.operator.io/v1", Kind: "Chart"},
+			ObjectMeta: metav1.ObjectMeta{Name: "foo", UID: "1234", Generation: 2},
+			Spec:       stablev1.ChartSpec{NameSpaceSelector: "default", CreateNamespace: true, SuspendApply: true},
+		}
+		instance.Status.Resource = []corev1.ObjectReference{configMapRef("current"), configMapRef("old")}
+		r = &ChartReconciler{
+			Client: fake.NewFakeClientWithScheme(testScheme(), instance,
+				&corev1.ConfigMap{TypeMeta: configMapType, ObjectMeta: metav1.ObjectMeta{Name: "current", Namespace: "default"}, Data: map[string]string{"a": "1"}},
+				&corev1.ConfigMap{TypeMeta: configMapType, ObjectMeta: metav1.ObjectMeta{Name: "old", Namespace: "default"}},
+			),
+			Log:    ctrl.Log.WithName("test"),
+			Scheme: testScheme(),
+			Mapper: testMapper(),
+		}
+	})
+
+	rel := &release{digest: "a", chartRevision: "1.0.0", manifest: []byte(`
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: current
+data:
+  a: "2"
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: new
+---
+apiVersion: example.com/v1
+kind: Widget
+metadata:
+  name: widget
+---
+apiVersion: batch/v1
+kind: Job
+metadata:
+  name: migrate
+  annotations:
+    helm.sh/hook: pre-install
+`)}
+
+	It("should list the changes applying the release would make", func() {
+		changes, err := r.diffRelease(instance, rel)
+		Expect(err).NotTo(HaveOccurred())
+		actions := map[string]stablev1.ChangeAction{}
+		for _, c := range changes {
+			actions[c.Kind+"/"+c.Name] = c.Action
+		}
+		Expect(actions).To(Equal(map[string]stablev1.ChangeAction{
+			"Namespace/default": stablev1.ChangeCreate,
+			"ConfigMap/current": stablev1.ChangeUpdate,
+			"ConfigMap/new":     stablev1.ChangeCreate,
+			"Widget/widget":     stablev1.ChangeCreate,
+			"ConfigMap/old":     stablev1.ChangeDelete,
+		}))
+		for _, c := range changes {
+			if c.Action == stablev1.ChangeUpdate {
+				Expect(c.Fields).To(ContainElement("/data/a"))
+				Expect(c.Fields).NotTo(ContainElement(HavePrefix("/metadata/annotations")))
+				Expect(c.Patch).NotTo(ContainSubstring(lastAppliedAnnotation))
+			}
+		}
+	})
+
+	It("should redact the values of Secrets", func() {
+		secret := resourceChange(&unstructured.Unstructured{Object: map[string]interface{}{
+			"apiVersion": "v1", "kind": "Secret", "metadata": map[string]interface{}{"name": "creds"},
+		}}, stablev1.ChangeUpdate, []byte(`{"data":{"password":"aHVudGVyMg==","old":null},"stringData":{"token":"abc"}}`))
+		Expect(secret.Patch).NotTo(ContainSubstring("aHVudGVyMg=="))
+		Expect(secret.Patch).NotTo(ContainSubstring("abc"))
+		Expect(secret.Patch).To(ContainSubstring(`"password":"REDACTED"`))
+		Expect(secret.Patch).To(ContainSubstring(`"old":null`))
+		Expect(secret.Fields).To(Equal([]string{"/data/old", "/data/password", "/stringData/token"}))
+	})
+
+	It("should record the preview without changing the cluster", func() {
+		_, err := r.previewRelease(instance, rel)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(instance.Status.Diff.Revision).To(Equal("1.0.0"))
+		Expect(instance.Status.Diff.Changes).To(HaveLen(5))
+		applied := stablev1.FindCondition(instance.Status.Conditions, stablev1.ChartApplied)
+		Expect(applied.Reason).To(Equal(reasonApplySuspended))
+		Expect(applied.Message).To(Equal("Apply suspended, 3 to create, 1 to update and 1 to delete"))
+		Expect(instance.Status.ObservedGeneration).To(Equal(int64(2)))
+
+		err = r.Get(ctx, types.NamespacedName{Name: "new", Namespace: "default"}, &corev1.ConfigMap{})
+		Expect(apierrs.IsNotFound(err)).To(BeTrue())
+		cm := &corev1.ConfigMap{}
+		Expect(r.Get(ctx, types.NamespacedName{Name: "current", Namespace: "default"}, cm)).To(Succeed())
+		Expect(cm.Data["a"]).To(Equal("1"))
+	})
+
+	It("should keep an unchanged preview as it was", func() {
+		_, err := r.previewRelease(instance, rel)
+		Expect(err).NotTo(HaveOccurred())
+		previewed := metav1.NewTime(time.Now().Add(-time.Hour).Truncate(time.Second))
+		instance.Status.Diff.Time = previewed
+
+		_, err = r.previewRelease(instance, rel)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(instance.Status.Diff.Time).To(Equal(previewed))
+
+		changed := *rel
+		changed.digest = "b"
+		_, err = r.previewRelease(instance, &changed)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(instance.Status.Diff.Digest).To(Equal("b"))
+		Expect(instance.Status.Diff.Time).NotTo(Equal(previewed))
+	})
+
+	It("should list the fields a patch changes", func() {
+		patch := []byte(`{"$setElementOrder/containers":[{"name":"web"}],"metadata":{"labels":{"example.com/team":"a"}},"spec":{"replicas":3,"containers":[{"name":"web","image":"web:2"}]}}`)
+		Expect(patchFields(patch)).To(Equal([]string{"/metadata/labels/example.com~1team", "/spec/containers", "/spec/replicas"}))
+	})
+})
@@ -0,0 +1,121 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	stablev1 "github.com/Spazzy757/helm-operator/api/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrs "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var configMapType = metav1.TypeMeta{APIVersion: "v1", Kind: "ConfigMap"}
+
+func configMapRef(name string) corev1.ObjectReference {
+	return corev1.ObjectReference{APIVersion: "v1", Kind: "ConfigMap", Name: name, Namespace: "default"}
+}
+
+var _ = Describe("pruneResources", func() {
+	var (
+		r        *ChartReconciler
+		instance *stablev1.Chart
+	)
+
+	BeforeEach(func() {
+		instance = &stablev1.Chart{ObjectMeta: metav1.ObjectMeta{Name: "foo", UID: "1234"}}
+		owner := metav1.OwnerReference{APIVersion: "stable.helm.operator.io/v1", Kind: "Chart", Name: "foo", UID: "1234"}
+		kept := &corev1.ConfigMap{TypeMeta: configMapType, ObjectMeta: metav1.ObjectMeta{
+			Name:            "kept",
+			Namespace:       "default",
+			Annotations:     map[string]string{resourcePolicyAnnotation: keepPolicy},
+			OwnerReferences: []metav1.OwnerReference{owner},
+		}}
+		r = &ChartReconciler{
+			Client: fake.NewFakeClientWithScheme(scheme.Scheme,
+				&corev1.ConfigMap{TypeMeta: configMapType, ObjectMeta: metav1.ObjectMeta{Name: "current", Namespace: "default"}},
+				&corev1.ConfigMap{TypeMeta: configMapType, ObjectMeta: metav1.ObjectMeta{Name: "orphan", Namespace: "default"}},
+				kept,
+			),
+			Log:    ctrl.Log.WithName("test"),
+			Scheme: scheme.Scheme,
+		}
+		instance.Status.Resource = []corev1.ObjectReference{
+			configMapRef("current"), configMapRef("orphan"), configMapRef("kept"), configMapRef("gone"),
+		}
+	})
+
+	It("should delete resources the chart no longer renders", func() {
+		rendered := []corev1.ObjectReference{configMapRef("current")}
+		Expect(r.pruneResources(instance, rendered)).To(Succeed())
+		Expect(instance.Status.Resource).To(Equal(rendered))
+
+		cm := &corev1.ConfigMap{}
+		Expect(r.Get(ctx, types.NamespacedName{Name: "current", Namespace: "default"}, cm)).To(Succeed())
+		err := r.Get(ctx, types.NamespacedName{Name: "orphan", Namespace: "default"}, cm)
+		Expect(apierrs.IsNotFound(err)).To(BeTrue())
+	})
+
+	It("should release resources annotated to be kept", func() {
+		Expect(r.pruneResources(instance, nil)).To(Succeed())
+
+		cm := &corev1.ConfigMap{}
+		Expect(r.Get(ctx, types.NamespacedName{Name: "kept", Namespace: "default"}, cm)).To(Succeed())
+		Expect(cm.OwnerReferences).To(BeEmpty())
+	})
+})
+
+var _ = Describe("refInSlice", func() {
+	It("should match refs by group, kind, namespace and name", func() {
+		deployment := func(apiVersion, namespace, name string) corev1.ObjectReference {
+			return corev1.ObjectReference{APIVersion: apiVersion, Kind: "Deployment", Namespace: namespace, Name: name}
+		}
+		list := []corev1.ObjectReference{deployment("apps/v1", "default", "web")}
+		Expect(refInSlice(deployment("apps/v1beta2", "default", "web"), list)).To(BeTrue())
+		Expect(refInSlice(deployment("extensions/v1beta1", "default", "web"), list)).To(BeTrue())
+		Expect(refInSlice(deployment("apps/v1", "other", "web"), list)).To(BeFalse())
+		Expect(refInSlice(deployment("apps/v1", "default", "api"), list)).To(BeFalse())
+	})
+
+	It("should not match other kinds of an aliased group", func() {
+		list := []corev1.ObjectReference{{APIVersion: "apps/v1", Kind: "Deployment", Namespace: "default", Name: "web"}}
+		ref := corev1.ObjectReference{APIVersion: "extensions/v1beta1", Kind: "Ingress", Namespace: "default", Name: "web"}
+		Expect(refInSlice(ref, list)).To(BeFalse())
+	})
+})
+
+var _ = Describe("decodeManifest", func() {
+	It("should decode every object of a manifest", func() {
+		objects, err := decodeManifest([]byte("---\n# Source: mychart/templates/a.yaml\nkind: ConfigMap\napiVersion: v1\nmetadata:\n  name: a\n---\n# Source: mychart/templates/notes.yaml\n# only comments\n"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(objects).To(HaveLen(1))
+		Expect(objects[0].GetName()).To(Equal("a"))
+	})
+
+	It("should report documents that cannot be decoded", func() {
+		objects, err := decodeManifest([]byte("---\n# Source: mychart/templates/a.yaml\nkind: ConfigMap\n  name: [a\n"))
+		Expect(objects).To(BeNil())
+		Expect(failureReason(err)).To(Equal(reasonInvalidManifest))
+		Expect(err.Error()).To(HavePrefix("unable to decode mychart/templates/a.yaml: "))
+		Expect(stalledReasons[reasonInvalidManifest]).To(BeTrue())
+	})
+})
@@ -0,0 +1,130 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"time"
+
+	stablev1 "github.com/Spazzy757/helm-operator/api/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+const (
+	// A release failed more often than its remediation retries it
+	reasonRetriesExhausted = "RetriesExhausted"
+)
+
+var (
+	// Delay before the first retry of a failed release
+	defaultBackoff = 30 * time.Second
+	// Longest delay between retries of a failed release
+	defaultMaxBackoff = 10 * time.Minute
+)
+
+// Returns the remediation of the next release, the install remediation until
+// a revision is deployed and the upgrade remediation after
+func remediationFor(instance *stablev1.Chart) *stablev1.Remediation {
+	if instance.Status.CurrentRevision == 0 {
+		if instance.Spec.Install != nil {
+			return instance.Spec.Install.Remediation
+		}
+		return nil
+	}
+	if instance.Spec.Upgrade != nil {
+		return instance.Spec.Upgrade.Remediation
+	}
+	return nil
+}
+
+// Returns whether a release failed more often than it is retried
+func retriesExhausted(remediation *stablev1.Remediation, failures int64) bool {
+	return remediation != nil && remediation.Retries >= 0 && failures > int64(remediation.Retries)
+}
+
+// Returns the delay before retrying a release after the given number of
+// failures, doubled after each failure up to the max backoff
+func backoff(remediation *stablev1.Remediation, failures int64) time.Duration {
+	delay, max := defaultBackoff, defaultMaxBackoff
+	if remediation != nil && remediation.Backoff != nil {
+		delay = remediation.Backoff.Duration
+	}
+	if remediation != nil && remediation.MaxBackoff != nil {
+		max = remediation.MaxBackoff.Duration
+	}
+	for i := int64(1); i < failures && delay < max; i++ {
+		delay *= 2
+	}
+	if delay > max {
+		return max
+	}
+	return delay
+}
+
+// Returns whether the release already failed every retry, it is not
+// attempted again until the chart or its values change
+func releaseStalled(instance *stablev1.Chart, rel *release) bool {
+	latest := latestRevision(instance)
+	stalled := stablev1.FindCondition(instance.Status.Conditions, stablev1.ChartStalled)
+	return latest != nil && latest.Digest == rel.digest && latest.Outcome == stablev1.ReleaseFailed &&
+		stalled != nil && stalled.Reason == reasonRetriesExhausted
+}
+
+// Counts a failed release and decides when it is retried. Once the retries
+// are used up the strategy of the remediation is applied and the chart is
+// stalled
+func (r *ChartReconciler) remediate(instance *stablev1.Chart) (ctrl.Result, bool) {
+	remediation := remediationFor(instance)
+	instance.Status.Failures++
+	if latest := latestRevision(instance); latest != nil && latest.Digest == instance.Status.RolledBackDigest {
+		// rolled back on failure, the rollback is deployed next
+		return ctrl.Result{Requeue: true}, false
+	}
+	if !retriesExhausted(remediation, instance.Status.Failures) {
+		return ctrl.Result{RequeueAfter: backoff(remediation, instance.Status.Failures)}, false
+	}
+
+	log := r.Log.WithValues("chart", instance.GetName())
+	switch remediation.Strategy {
+	case stablev1.RemediationRollback:
+		if instance.Status.CurrentRevision == 0 {
+			break
+		}
+		log.Info("retries exhausted, rolling back", "revision", instance.Status.CurrentRevision)
+		instance.Status.RolledBackDigest = latestRevision(instance).Digest
+		return ctrl.Result{Requeue: true}, true
+	case stablev1.RemediationUninstall:
+		log.Info("retries exhausted, uninstalling")
+		if err := r.deleteExternalResources(instance); err != nil {
+			log.Error(err, "unable to uninstall failed release")
+			return ctrl.Result{RequeueAfter: backoff(remediation, instance.Status.Failures)}, false
+		}
+		uninstalledRevision(instance)
+	}
+	return ctrl.Result{}, true
+}
+
+// Forgets the resources and the deployed revision of an uninstalled chart
+func uninstalledRevision(instance *stablev1.Chart) {
+	for i := range instance.Status.History {
+		if instance.Status.History[i].Outcome == stablev1.ReleaseDeployed {
+			instance.Status.History[i].Outcome = stablev1.ReleaseSuperseded
+		}
+	}
+	instance.Status.Resource = nil
+	instance.Status.ResourceHealth = nil
+	instance.Status.CurrentRevision = 0
+	instance.Status.DeployedDigest = ""
+}
@@ -0,0 +1,143 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"errors"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	stablev1 "github.com/Spazzy757/helm-operator/api/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrs "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var _ = Describe("remediation", func() {
+	var (
+		r        *ChartReconciler
+		instance *stablev1.Chart
+	)
+
+	BeforeEach(func() {
+		instance = &stablev1.Chart{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "stable.helm.operator.io/v1", Kind: "Chart"},
+			ObjectMeta: metav1.ObjectMeta{Name: "foo", UID: "1234"},
+			Spec:       stablev1.ChartSpec{NameSpaceSelector: "apps"},
+		}
+		r = &ChartReconciler{
+			Client: fake.NewFakeClientWithScheme(testScheme(), instance),
+			Log:    ctrl.Log.WithName("test"),
+			Scheme: testScheme(),
+		}
+	})
+
+	attempt := func(digest string) *release {
+		rel := &release{manifest: []byte("kind: ConfigMap"), digest: digest, chartRevision: "1.0.0"}
+		Expect(r.recordRevision(instance, rel)).To(Succeed())
+		return rel
+	}
+
+	stalled := func() *stablev1.Condition {
+		return stablev1.FindCondition(instance.Status.Conditions, stablev1.ChartStalled)
+	}
+
+	It("should double the backoff up to the max", func() {
+		remediation := &stablev1.Remediation{
+			Backoff:    &metav1.Duration{Duration: 10 * time.Second},
+			MaxBackoff: &metav1.Duration{Duration: time.Minute},
+		}
+		Expect(backoff(remediation, 1)).To(Equal(10 * time.Second))
+		Expect(backoff(remediation, 3)).To(Equal(40 * time.Second))
+		Expect(backoff(remediation, 10)).To(Equal(time.Minute))
+		Expect(backoff(nil, 2)).To(Equal(2 * defaultBackoff))
+	})
+
+	It("should retry failed releases forever without a remediation", func() {
+		attempt("a")
+		for i := 1; i <= 3; i++ {
+			result, err := r.failed(instance, stablev1.ChartApplied, errors.New("forbidden"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.RequeueAfter).To(Equal(backoff(nil, int64(i))))
+			attempt("a")
+		}
+		Expect(instance.Status.Failures).To(Equal(int64(3)))
+		Expect(stalled()).To(BeNil())
+	})
+
+	It("should stall once the retries are used up", func() {
+		instance.Spec.Install = &stablev1.InstallSpec{Remediation: &stablev1.Remediation{Retries: 1}}
+		rel := attempt("a")
+		_, err := r.failed(instance, stablev1.ChartApplied, errors.New("forbidden"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(stalled()).To(BeNil())
+		Expect(releaseStalled(instance, rel)).To(BeFalse())
+
+		attempt("a")
+		result, err := r.failed(instance, stablev1.ChartApplied, errors.New("forbidden"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result).To(Equal(ctrl.Result{}))
+		Expect(instance.Status.Failures).To(Equal(int64(2)))
+		Expect(stalled().Reason).To(Equal(reasonRetriesExhausted))
+		Expect(releaseStalled(instance, rel)).To(BeTrue())
+
+		// a new release is attempted again
+		Expect(releaseStalled(instance, &release{digest: "b"})).To(BeFalse())
+		attempt("b")
+		Expect(instance.Status.Failures).To(BeZero())
+	})
+
+	It("should roll back upgrades once the retries are used up", func() {
+		attempt("a")
+		deployedRevision(instance)
+		instance.Spec.Upgrade = &stablev1.UpgradeSpec{Remediation: &stablev1.Remediation{Strategy: stablev1.RemediationRollback}}
+		attempt("b")
+
+		result, err := r.failed(instance, stablev1.ChartApplied, errors.New("forbidden"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.Requeue).To(BeTrue())
+		Expect(instance.Status.RolledBackDigest).To(Equal("b"))
+		Expect(stalled().Reason).To(Equal(reasonRetriesExhausted))
+
+		rel, err := r.targetRelease(instance, &release{digest: "b"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(rel.rollbackOf).To(Equal(int64(1)))
+		Expect(releaseStalled(instance, rel)).To(BeFalse())
+	})
+
+	It("should uninstall once the retries are used up", func() {
+		cm := &corev1.ConfigMap{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "ConfigMap"},
+			ObjectMeta: metav1.ObjectMeta{Name: "foo", Namespace: "apps"},
+		}
+		r.Client = fake.NewFakeClientWithScheme(testScheme(), instance, cm)
+		instance.Spec.Install = &stablev1.InstallSpec{Remediation: &stablev1.Remediation{Strategy: stablev1.RemediationUninstall}}
+		instance.Status.Resource = []corev1.ObjectReference{{APIVersion: "v1", Kind: "ConfigMap", Name: "foo", Namespace: "apps"}}
+		attempt("a")
+
+		_, err := r.failed(instance, stablev1.ChartApplied, errors.New("forbidden"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(stalled().Reason).To(Equal(reasonRetriesExhausted))
+		Expect(instance.Status.Resource).To(BeEmpty())
+		err = r.Get(ctx, types.NamespacedName{Namespace: "apps", Name: "foo"}, &corev1.ConfigMap{})
+		Expect(apierrs.IsNotFound(err)).To(BeTrue())
+	})
+})
@@ -0,0 +1,138 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"time"
+
+	stablev1 "github.com/Spazzy757/helm-operator/api/v1"
+	"github.com/Spazzy757/helm-operator/render"
+	"github.com/Spazzy757/helm-operator/repository"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/helm/pkg/proto/hapi/chart"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+const (
+	// Hooks or resources of the release have not completed yet
+	reasonProgressing = "Progressing"
+)
+
+// Reasons of failures that retrying cannot fix, the chart is stalled until
+// its spec or values change
+var stalledReasons = map[string]bool{
+	string(render.ReasonLoadFailed):         true,
+	string(render.ReasonInvalidValues):      true,
+	string(render.ReasonTemplateFailed):     true,
+	string(repository.ReasonInvalidVersion): true,
+	reasonNamespaceForbidden:                true,
+	reasonInvalidDriftIgnore:                true,
+	reasonPolicyViolation:                   true,
+	reasonInvalidManifest:                   true,
+}
+
+// Reasons of the conditions of failed steps when the error has none
+var defaultFailureReasons = map[string]string{
+	stablev1.ChartFetched:  "FetchFailed",
+	stablev1.ChartRendered: "RenderFailed",
+	stablev1.ChartApplied:  "ApplyFailed",
+	stablev1.ChartReady:    "NotReady",
+}
+
+// Records the failure of a step of the reconcile in the status of the
+// instance, the condition of the step and Ready are set to False. Failed
+// releases are retried as their remediation allows, stalled failures are not
+// retried as they are reconciled again once the chart or its values change
+func (r *ChartReconciler) failed(instance *stablev1.Chart, conditionType string, err error) (ctrl.Result, error) {
+	reason := failureReason(err)
+	if reason == "" {
+		reason = defaultFailureReasons[conditionType]
+	}
+	for _, t := range []string{conditionType, stablev1.ChartReady} {
+		stablev1.SetCondition(&instance.Status.Conditions, stablev1.Condition{
+			Type:    t,
+			Status:  corev1.ConditionFalse,
+			Reason:  reason,
+			Message: err.Error(),
+		})
+	}
+	stalled := stalledReasons[reason]
+	stalledReason := reason
+	// failed releases are retried with a backoff, and remediated once the
+	// retries are used up
+	var result ctrl.Result
+	if failedRevision(instance, err) && !stalled {
+		result, stalled = r.remediate(instance)
+		stalledReason = reasonRetriesExhausted
+	}
+	if stalled {
+		stablev1.SetCondition(&instance.Status.Conditions, stablev1.Condition{
+			Type:    stablev1.ChartStalled,
+			Status:  corev1.ConditionTrue,
+			Reason:  stalledReason,
+			Message: err.Error(),
+		})
+	} else {
+		stablev1.RemoveCondition(&instance.Status.Conditions, stablev1.ChartStalled)
+	}
+	instance.Status.Status = "Failed"
+	instance.Status.ObservedGeneration = instance.GetGeneration()
+	if err := r.UpdateStatus(instance); err != nil {
+		return ctrl.Result{}, err
+	}
+	if stalled || result != (ctrl.Result{}) {
+		return result, nil
+	}
+	return ctrl.Result{}, err
+}
+
+// Records that the release waits for hooks or resources to complete, the
+// chart is reconciled again after the delay rather than blocking the worker
+func (r *ChartReconciler) progressing(instance *stablev1.Chart, message string, after time.Duration) (ctrl.Result, error) {
+	stablev1.SetCondition(&instance.Status.Conditions, stablev1.Condition{
+		Type:    stablev1.ChartReady,
+		Status:  corev1.ConditionFalse,
+		Reason:  reasonProgressing,
+		Message: message,
+	})
+	if err := r.UpdateStatus(instance); err != nil {
+		return ctrl.Result{}, err
+	}
+	return ctrl.Result{RequeueAfter: after}, nil
+}
+
+// Marks a step of the reconcile as done
+func succeeded(instance *stablev1.Chart, conditionType, reason, message string) {
+	stablev1.SetCondition(&instance.Status.Conditions, stablev1.Condition{
+		Type:    conditionType,
+		Status:  corev1.ConditionTrue,
+		Reason:  reason,
+		Message: message,
+	})
+}
+
+// Returns the metadata of a fetched chart and its revision, the commit for git
+// sources and the version in Chart.yaml otherwise
+func chartRevision(chartPath, commit string) (*chart.Metadata, string, error) {
+	c, err := render.Load(chartPath)
+	if err != nil {
+		return nil, "", err
+	}
+	if commit != "" {
+		return c.Metadata, commit, nil
+	}
+	return c.Metadata, c.Metadata.Version, nil
+}
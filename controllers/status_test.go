@@ -0,0 +1,88 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"errors"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	stablev1 "github.com/Spazzy757/helm-operator/api/v1"
+	"github.com/Spazzy757/helm-operator/render"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var _ = Describe("status", func() {
+	var (
+		r        *ChartReconciler
+		instance *stablev1.Chart
+	)
+
+	BeforeEach(func() {
+		instance = &stablev1.Chart{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "stable.helm.operator.io/v1", Kind: "Chart"},
+			ObjectMeta: metav1.ObjectMeta{Name: "foo", Generation: 3},
+		}
+		r = &ChartReconciler{
+			Client: fake.NewFakeClientWithScheme(testScheme(), instance),
+			Log:    ctrl.Log.WithName("test"),
+			Scheme: testScheme(),
+		}
+	})
+
+	condition := func(conditionType string) *stablev1.Condition {
+		return stablev1.FindCondition(instance.Status.Conditions, conditionType)
+	}
+
+	It("should set the condition of the failed step and Ready to False", func() {
+		_, err := r.failed(instance, stablev1.ChartFetched, errors.New("connection refused"))
+		Expect(err).To(HaveOccurred())
+		for _, t := range []string{stablev1.ChartFetched, stablev1.ChartReady} {
+			Expect(condition(t).Status).To(Equal(corev1.ConditionFalse))
+			Expect(condition(t).Reason).To(Equal("FetchFailed"))
+			Expect(condition(t).Message).To(Equal("connection refused"))
+		}
+		Expect(condition(stablev1.ChartStalled)).To(BeNil())
+		Expect(instance.Status.ObservedGeneration).To(Equal(int64(3)))
+		Expect(instance.Status.Status).To(Equal("Failed"))
+	})
+
+	It("should stall on failures retrying cannot fix", func() {
+		renderErr := &render.Error{Reason: render.ReasonTemplateFailed, Err: errors.New("bad template")}
+		_, err := r.failed(instance, stablev1.ChartRendered, renderErr)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(condition(stablev1.ChartRendered).Reason).To(Equal(string(render.ReasonTemplateFailed)))
+		Expect(condition(stablev1.ChartStalled).Status).To(Equal(corev1.ConditionTrue))
+
+		_, err = r.failed(instance, stablev1.ChartRendered, errors.New("timeout"))
+		Expect(err).To(HaveOccurred())
+		Expect(condition(stablev1.ChartStalled)).To(BeNil())
+	})
+
+	It("should use the chart version or git commit as revision", func() {
+		metadata, revision, err := chartRevision("../render/testdata/mychart", "")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(metadata.Name).To(Equal("mychart"))
+		Expect(revision).To(Equal("0.1.0"))
+		_, revision, err = chartRevision("../render/testdata/mychart", "abc123")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(revision).To(Equal("abc123"))
+	})
+})
@@ -0,0 +1,182 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	stablev1 "github.com/Spazzy757/helm-operator/api/v1"
+	"github.com/Spazzy757/helm-operator/render"
+	corev1 "k8s.io/api/core/v1"
+	apierrs "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/yaml"
+)
+
+// Key read from referenced objects when none is set
+const defaultValuesKey = "values.yaml"
+
+// Builds the values on the instance into the nested form used by charts, the
+// values object is merged over base and set values are applied over both like
+// `helm --set` over `--values`
+func buildValues(c *stablev1.Chart, base map[string]interface{}) (map[string]interface{}, error) {
+	values := map[string]interface{}{}
+	setValues := c.Spec.SetValues
+	if c.Spec.Values != nil {
+		raw := bytes.TrimSpace(c.Spec.Values.Raw)
+		switch {
+		case len(raw) == 0 || bytes.Equal(raw, []byte("null")):
+		case raw[0] == '[':
+			// charts created before values became an object hold name/value pairs
+			var legacy []stablev1.Value
+			if err := json.Unmarshal(raw, &legacy); err != nil {
+				return nil, &render.Error{Reason: render.ReasonInvalidValues, Err: err}
+			}
+			setValues = append(legacy, setValues...)
+		default:
+			if err := json.Unmarshal(raw, &values); err != nil {
+				return nil, &render.Error{Reason: render.ReasonInvalidValues, Err: err}
+			}
+		}
+	}
+	values = render.CoalesceValues(values, base)
+	for _, valuePair := range setValues {
+		var value interface{} = valuePair.Value
+		if !valuePair.ForceString {
+			value = render.ParseLiteral(valuePair.Value)
+		}
+		if err := render.SetValue(values, valuePair.Name, value); err != nil {
+			return nil, err
+		}
+	}
+	return values, nil
+}
+
+// Merges the values of the referenced ConfigMaps and Secrets in order, later
+// references override earlier ones
+func (r *ChartReconciler) valuesFrom(c *stablev1.Chart) (map[string]interface{}, error) {
+	values := map[string]interface{}{}
+	for _, ref := range c.Spec.ValuesFrom {
+		data, found, err := r.readValuesReference(c, ref)
+		if err != nil {
+			return nil, err
+		}
+		if !found {
+			if ref.Optional {
+				continue
+			}
+			return nil, &render.Error{
+				Reason: render.ReasonInvalidValues,
+				Err:    fmt.Errorf("%s %s has no key %s", ref.Kind, valuesNamespacedName(c, ref), valuesKey(ref)),
+			}
+		}
+
+		next := map[string]interface{}{}
+		if ref.TargetPath != "" {
+			if err := render.SetValue(next, ref.TargetPath, string(data)); err != nil {
+				return nil, err
+			}
+		} else if err := yaml.Unmarshal(data, &next); err != nil {
+			return nil, &render.Error{
+				Reason: render.ReasonInvalidValues,
+				Err:    fmt.Errorf("%s %s key %s: %v", ref.Kind, valuesNamespacedName(c, ref), valuesKey(ref), err),
+			}
+		}
+		values = render.CoalesceValues(next, values)
+	}
+	return values, nil
+}
+
+// Returns the content of the key of a referenced object and whether it exists
+func (r *ChartReconciler) readValuesReference(c *stablev1.Chart, ref stablev1.ValuesReference) ([]byte, bool, error) {
+	key := valuesKey(ref)
+	switch ref.Kind {
+	case "ConfigMap":
+		cm := &corev1.ConfigMap{}
+		if err := r.Get(ctx, valuesNamespacedName(c, ref), cm); err != nil {
+			if apierrs.IsNotFound(err) {
+				return nil, false, nil
+			}
+			return nil, false, err
+		}
+		if data, ok := cm.Data[key]; ok {
+			return []byte(data), true, nil
+		}
+		data, ok := cm.BinaryData[key]
+		return data, ok, nil
+	case "Secret":
+		secret := &corev1.Secret{}
+		if err := r.Get(ctx, valuesNamespacedName(c, ref), secret); err != nil {
+			if apierrs.IsNotFound(err) {
+				return nil, false, nil
+			}
+			return nil, false, err
+		}
+		data, ok := secret.Data[key]
+		return data, ok, nil
+	}
+	return nil, false, &render.Error{
+		Reason: render.ReasonInvalidValues,
+		Err:    fmt.Errorf("unsupported valuesFrom kind %q, expected ConfigMap or Secret", ref.Kind),
+	}
+}
+
+// Maps a ConfigMap or Secret to the charts reading values from it
+func (r *ChartReconciler) chartsForValues(kind string) handler.ToRequestsFunc {
+	return func(o handler.MapObject) []ctrl.Request {
+		charts, err := r.listCharts()
+		if err != nil {
+			r.Log.Error(err, "unable to list charts", "kind", kind, "name", o.Meta.GetName())
+			return nil
+		}
+		var requests []ctrl.Request
+		for i := range charts {
+			c := &charts[i]
+			for _, ref := range c.Spec.ValuesFrom {
+				key := valuesNamespacedName(c, ref)
+				if ref.Kind == kind && key.Name == o.Meta.GetName() && key.Namespace == o.Meta.GetNamespace() {
+					requests = append(requests, ctrl.Request{
+						NamespacedName: types.NamespacedName{Namespace: c.GetNamespace(), Name: c.GetName()},
+					})
+					break
+				}
+			}
+		}
+		return requests
+	}
+}
+
+// Returns the name of a referenced object, the namespace defaults to the
+// namespace the chart is deployed to
+func valuesNamespacedName(c *stablev1.Chart, ref stablev1.ValuesReference) types.NamespacedName {
+	namespace := ref.Namespace
+	if namespace == "" {
+		namespace = c.Spec.NameSpaceSelector
+	}
+	return types.NamespacedName{Namespace: namespace, Name: ref.Name}
+}
+
+// Returns the key read from a referenced object
+func valuesKey(ref stablev1.ValuesReference) string {
+	if ref.Key == "" {
+		return defaultValuesKey
+	}
+	return ref.Key
+}
@@ -0,0 +1,165 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	stablev1 "github.com/Spazzy757/helm-operator/api/v1"
+	"github.com/Spazzy757/helm-operator/render"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+)
+
+var _ = Describe("buildValues", func() {
+	chart := func(values string, setValues ...stablev1.Value) *stablev1.Chart {
+		c := &stablev1.Chart{}
+		if values != "" {
+			c.Spec.Values = &runtime.RawExtension{Raw: []byte(values)}
+		}
+		c.Spec.SetValues = setValues
+		return c
+	}
+
+	It("should keep nested values and their types", func() {
+		values, err := buildValues(chart(`{"controller": {"name": "a,b=c[0]", "replicaCount": 4, "autoscaling": {"enabled": true}}}`), nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(values).To(Equal(map[string]interface{}{
+			"controller": map[string]interface{}{
+				"name":         "a,b=c[0]",
+				"replicaCount": float64(4),
+				"autoscaling":  map[string]interface{}{"enabled": true},
+			},
+		}))
+	})
+
+	It("should apply set values over the values object", func() {
+		values, err := buildValues(chart(`{"image": {"tag": "1.0"}, "replicas": 1}`,
+			stablev1.Value{Name: "image.tag", Value: "2.0"},
+			stablev1.Value{Name: "replicas", Value: "3"},
+			stablev1.Value{Name: "annotations.example\\.com/key", Value: "x,y"},
+		), nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(values["image"]).To(Equal(map[string]interface{}{"tag": "2.0"}))
+		Expect(values["replicas"]).To(Equal(int64(3)))
+		Expect(values["annotations"]).To(Equal(map[string]interface{}{"example.com/key": "x,y"}))
+	})
+
+	It("should keep forced strings as strings", func() {
+		values, err := buildValues(chart("",
+			stablev1.Value{Name: "enabled", Value: "true", ForceString: true},
+			stablev1.Value{Name: "port", Value: "8080", ForceString: true},
+			stablev1.Value{Name: "debug", Value: "true"},
+		), nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(values).To(Equal(map[string]interface{}{"enabled": "true", "port": "8080", "debug": true}))
+	})
+
+	It("should read values stored in the list form", func() {
+		values, err := buildValues(chart(`[{"name": "controller.replicaCount", "value": "4"}]`,
+			stablev1.Value{Name: "controller.name", Value: "foo"},
+		), nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(values).To(Equal(map[string]interface{}{
+			"controller": map[string]interface{}{"replicaCount": int64(4), "name": "foo"},
+		}))
+	})
+
+	It("should reject values that are not an object", func() {
+		_, err := buildValues(chart(`"foo"`), nil)
+		Expect(render.ReasonFor(err)).To(Equal(render.ReasonInvalidValues))
+	})
+})
+
+var _ = Describe("valuesFrom", func() {
+	var (
+		r     *ChartReconciler
+		chart *stablev1.Chart
+	)
+
+	BeforeEach(func() {
+		shared := &corev1.ConfigMap{
+			TypeMeta:   configMapType,
+			ObjectMeta: metav1.ObjectMeta{Name: "shared", Namespace: "apps"},
+			Data: map[string]string{
+				"values.yaml": "image:\n  repository: nginx\n  tag: \"1.0\"\nreplicas: 2\n",
+				"override":    "replicas: 3\n",
+			},
+		}
+		credentials := &corev1.Secret{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Secret"},
+			ObjectMeta: metav1.ObjectMeta{Name: "credentials", Namespace: "apps"},
+			Data:       map[string][]byte{"password": []byte("s3cr3t,=[0]")},
+		}
+		r = &ChartReconciler{
+			Client: fake.NewFakeClientWithScheme(testScheme(), shared, credentials),
+			Log:    ctrl.Log.WithName("test"),
+		}
+		chart = &stablev1.Chart{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "stable.helm.operator.io/v1", Kind: "Chart"},
+			ObjectMeta: metav1.ObjectMeta{Name: "app"},
+			Spec: stablev1.ChartSpec{
+				NameSpaceSelector: "apps",
+				ValuesFrom: []stablev1.ValuesReference{
+					{Kind: "ConfigMap", Name: "shared"},
+					{Kind: "ConfigMap", Name: "shared", Key: "override"},
+					{Kind: "Secret", Name: "credentials", Key: "password", TargetPath: "auth.password"},
+					{Kind: "Secret", Name: "missing", Optional: true},
+				},
+				Values: &runtime.RawExtension{Raw: []byte(`{"image": {"tag": "2.0"}}`)},
+			},
+		}
+	})
+
+	It("should merge references in order under the inline values", func() {
+		base, err := r.valuesFrom(chart)
+		Expect(err).NotTo(HaveOccurred())
+		values, err := buildValues(chart, base)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(values).To(Equal(map[string]interface{}{
+			"image":    map[string]interface{}{"repository": "nginx", "tag": "2.0"},
+			"replicas": float64(3),
+			"auth":     map[string]interface{}{"password": "s3cr3t,=[0]"},
+		}))
+	})
+
+	It("should fail on missing references that are not optional", func() {
+		chart.Spec.ValuesFrom = append(chart.Spec.ValuesFrom, stablev1.ValuesReference{Kind: "ConfigMap", Name: "shared", Key: "missing"})
+		_, err := r.valuesFrom(chart)
+		Expect(render.ReasonFor(err)).To(Equal(render.ReasonInvalidValues))
+	})
+
+	It("should map changed objects to the charts referencing them", func() {
+		Expect(r.Create(ctx, chart)).To(Succeed())
+		Expect(r.Create(ctx, &stablev1.Chart{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "stable.helm.operator.io/v1", Kind: "Chart"},
+			ObjectMeta: metav1.ObjectMeta{Name: "other"},
+			Spec:       stablev1.ChartSpec{NameSpaceSelector: "other"},
+		})).To(Succeed())
+
+		secret := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "credentials", Namespace: "apps"}}
+		requests := r.chartsForValues("Secret")(handler.MapObject{Meta: secret, Object: secret})
+		Expect(requests).To(ConsistOf(ctrl.Request{NamespacedName: types.NamespacedName{Name: "app"}}))
+
+		Expect(r.chartsForValues("ConfigMap")(handler.MapObject{Meta: secret, Object: secret})).To(BeEmpty())
+	})
+})
@@ -0,0 +1,90 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/Masterminds/semver"
+	stablev1 "github.com/Spazzy757/helm-operator/api/v1"
+	"github.com/Spazzy757/helm-operator/repository"
+)
+
+// How often charts with a version range are reconciled to pick up newly
+// published versions
+var versionSyncInterval = 10 * time.Minute
+
+// Resolves the version of the chart to fetch, ranges are resolved to the
+// newest of the versions listed in the repository. With the manual-approval
+// upgrade policy the deployed version is kept until a newer one is approved,
+// the newest one is left in status.availableVersion
+func resolveVersion(instance *stablev1.Chart, list func() ([]string, error)) (string, error) {
+	status := &instance.Status
+	if !repository.IsRange(instance.Spec.Version) {
+		status.ResolvedVersion, status.AvailableVersion = "", ""
+		return instance.Spec.Version, nil
+	}
+	versions, err := list()
+	if err != nil {
+		return "", err
+	}
+	matching, err := repository.MatchingVersions(versions, instance.Spec.Version)
+	if err != nil {
+		return "", err
+	}
+	if len(matching) == 0 {
+		return "", &repository.Error{
+			Reason: repository.ReasonVersionNotFound,
+			Err:    fmt.Errorf("chart %q has no version in range %q", instance.Spec.Chart, instance.Spec.Version),
+		}
+	}
+	newest, resolved := matching[0], matching[0]
+	if instance.Spec.UpgradePolicy == stablev1.UpgradePolicyManualApproval {
+		// a range no longer including the deployed version is an approval
+		if deployed := status.LastAppliedRevision; inRange(deployed, instance.Spec.Version) {
+			resolved = deployed
+			if approved := instance.Spec.ApprovedVersion; containsString(matching, approved) && newer(approved, deployed) {
+				resolved = approved
+			}
+		}
+	}
+	status.ResolvedVersion = resolved
+	status.AvailableVersion = ""
+	if newer(newest, resolved) {
+		status.AvailableVersion = newest
+	}
+	return resolved, nil
+}
+
+// Returns whether version is a semver version in the range
+func inRange(version, r string) bool {
+	matching, err := repository.MatchingVersions([]string{version}, r)
+	return err == nil && len(matching) == 1
+}
+
+// Returns whether version a is newer than b, both being semver versions
+func newer(a, b string) bool {
+	va, err := semver.NewVersion(a)
+	if err != nil {
+		return false
+	}
+	vb, err := semver.NewVersion(b)
+	if err != nil {
+		return false
+	}
+	return va.GreaterThan(vb)
+}
@@ -0,0 +1,80 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	stablev1 "github.com/Spazzy757/helm-operator/api/v1"
+	"github.com/Spazzy757/helm-operator/repository"
+)
+
+var _ = Describe("version ranges", func() {
+	var (
+		instance *stablev1.Chart
+		listed   int
+	)
+
+	list := func() ([]string, error) {
+		listed++
+		return []string{"1.1.0", "1.2.0", "1.2.3", "2.0.0"}, nil
+	}
+
+	BeforeEach(func() {
+		listed = 0
+		instance = &stablev1.Chart{Spec: stablev1.ChartSpec{Chart: "nginx", Version: "~1.2"}}
+	})
+
+	It("should use exact versions as they are", func() {
+		instance.Spec.Version = "1.1.0"
+		instance.Status.ResolvedVersion = "1.2.3"
+		Expect(resolveVersion(instance, list)).To(Equal("1.1.0"))
+		Expect(listed).To(BeZero())
+		Expect(instance.Status.ResolvedVersion).To(BeEmpty())
+	})
+
+	It("should roll out the newest matching version", func() {
+		instance.Status.LastAppliedRevision = "1.2.0"
+		Expect(resolveVersion(instance, list)).To(Equal("1.2.3"))
+		Expect(instance.Status.ResolvedVersion).To(Equal("1.2.3"))
+		Expect(instance.Status.AvailableVersion).To(BeEmpty())
+	})
+
+	It("should fail when no version matches", func() {
+		instance.Spec.Version = ">=3"
+		_, err := resolveVersion(instance, list)
+		Expect(repository.ReasonFor(err)).To(Equal(repository.ReasonVersionNotFound))
+	})
+
+	It("should wait for approval before rolling out newer versions", func() {
+		instance.Spec.UpgradePolicy = stablev1.UpgradePolicyManualApproval
+		Expect(resolveVersion(instance, list)).To(Equal("1.2.3"), "installs take the newest version")
+
+		instance.Status.LastAppliedRevision = "1.2.0"
+		Expect(resolveVersion(instance, list)).To(Equal("1.2.0"))
+		Expect(instance.Status.AvailableVersion).To(Equal("1.2.3"))
+
+		instance.Spec.ApprovedVersion = "1.2.3"
+		Expect(resolveVersion(instance, list)).To(Equal("1.2.3"))
+		Expect(instance.Status.ResolvedVersion).To(Equal("1.2.3"))
+		Expect(instance.Status.AvailableVersion).To(BeEmpty())
+
+		// changing the range approves the newest version in it
+		instance.Spec.Version = "^2"
+		Expect(resolveVersion(instance, list)).To(Equal("2.0.0"))
+	})
+})
@@ -3,13 +3,23 @@ module github.com/Spazzy757/helm-operator
 go 1.12
 
 require (
+	github.com/BurntSushi/toml v0.3.1 // indirect
+	github.com/Masterminds/goutils v1.1.1 // indirect
+	github.com/Masterminds/semver v1.5.0
+	github.com/Masterminds/sprig v2.20.0+incompatible
+	github.com/cyphar/filepath-securejoin v0.2.2 // indirect
+	github.com/ghodss/yaml v1.0.0 // indirect
 	github.com/go-logr/logr v0.1.0
+	github.com/gobwas/glob v0.2.3 // indirect
+	github.com/google/uuid v1.1.0 // indirect
+	github.com/huandu/xstrings v1.2.1 // indirect
 	github.com/onsi/ginkgo v1.6.0
 	github.com/onsi/gomega v1.4.2
 	golang.org/x/net v0.0.0-20180906233101-161cd47e91fd
 	k8s.io/api v0.0.0-20190409021203-6e4e0e4f393b
 	k8s.io/apimachinery v0.0.0-20190404173353-6a84e37a896d
 	k8s.io/client-go v11.0.1-0.20190409021438-1a26190bd76a+incompatible
+	k8s.io/helm v2.14.3+incompatible
 	sigs.k8s.io/controller-runtime v0.2.0-beta.2
 	sigs.k8s.io/yaml v1.1.0
 )
@@ -21,6 +21,8 @@ import (
 
 	stablev1 "github.com/Spazzy757/helm-operator/api/v1"
 	"github.com/Spazzy757/helm-operator/controllers"
+	"github.com/Spazzy757/helm-operator/render"
+	"github.com/Spazzy757/helm-operator/repository"
 	appsv1 "k8s.io/api/apps/v1"
 	appsv1beta1 "k8s.io/api/apps/v1beta1"
 	appsv1beta2 "k8s.io/api/apps/v1beta2"
@@ -57,8 +59,13 @@ import (
 	storagev1 "k8s.io/api/storage/v1"
 	storagev1alpha1 "k8s.io/api/storage/v1alpha1"
 	storagev1beta1 "k8s.io/api/storage/v1beta1"
+	"k8s.io/apimachinery/pkg/api/meta"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/discovery/cached/memory"
 	_ "k8s.io/client-go/plugin/pkg/client/auth/gcp"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/restmapper"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 	// +kubebuilder:scaffold:imports
@@ -114,9 +121,17 @@ func init() {
 func main() {
 	var metricsAddr string
 	var enableLeaderElection bool
+	var rendererName string
+	var chartCacheDir string
+	var enableWebhooks bool
 	flag.StringVar(&metricsAddr, "metrics-addr", ":8080", "The address the metric endpoint binds to.")
 	flag.BoolVar(&enableLeaderElection, "enable-leader-election", false,
 		"Enable leader election for controller manager. Enabling this will ensure there is only one active controller manager.")
+	flag.StringVar(&rendererName, "renderer", "engine",
+		"How charts are templated: engine renders in-process, helm runs the helm binary.")
+	flag.StringVar(&chartCacheDir, "chart-cache-dir", "charts", "The directory downloaded charts are cached in.")
+	flag.BoolVar(&enableWebhooks, "enable-webhooks", false,
+		"Serve the defaulting and validating webhooks of Chart, certificates are read from /tmp/k8s-webhook-server/serving-certs.")
 	flag.Parse()
 
 	ctrl.SetLogger(zap.Logger(true))
@@ -125,21 +140,71 @@ func main() {
 		Scheme:             scheme,
 		MetricsBindAddress: metricsAddr,
 		LeaderElection:     enableLeaderElection,
+		// A resettable mapper so kinds from CRDs installed by charts are discovered
+		MapperProvider: func(c *rest.Config) (meta.RESTMapper, error) {
+			dc, err := discovery.NewDiscoveryClientForConfig(c)
+			if err != nil {
+				return nil, err
+			}
+			return restmapper.NewDeferredDiscoveryRESTMapper(memory.NewMemCacheClient(dc)), nil
+		},
 	})
 	if err != nil {
 		setupLog.Error(err, "unable to start manager")
 		os.Exit(1)
 	}
 
+	var renderer render.Renderer
+	switch rendererName {
+	case "engine":
+		renderer = render.NewEngine()
+	case "helm":
+		renderer = &render.Exec{}
+	default:
+		setupLog.Info("unknown renderer", "renderer", rendererName)
+		os.Exit(1)
+	}
+
+	repositoryCache := repository.NewCache()
 	err = (&controllers.ChartReconciler{
-		Client: mgr.GetClient(),
-		Log:    ctrl.Log.WithName("controllers").WithName("Chart"),
-		Scheme: mgr.GetScheme(),
+		Client:   mgr.GetClient(),
+		Log:      ctrl.Log.WithName("controllers").WithName("Chart"),
+		Scheme:   mgr.GetScheme(),
+		Renderer: renderer,
+		Repositories: &repository.Client{
+			CacheDir: chartCacheDir,
+		},
+		RepositoryCache: repositoryCache,
+		Git: &repository.GitClient{
+			CacheDir: chartCacheDir,
+		},
+		Config: mgr.GetConfig(),
 	}).SetupWithManager(mgr)
 	if err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "Chart")
 		os.Exit(1)
 	}
+	err = (&controllers.ChartRepositoryReconciler{
+		Client:   mgr.GetClient(),
+		Log:      ctrl.Log.WithName("controllers").WithName("ChartRepository"),
+		Scheme:   mgr.GetScheme(),
+		Cache:    repositoryCache,
+		CacheDir: chartCacheDir,
+	}).SetupWithManager(mgr)
+	if err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "ChartRepository")
+		os.Exit(1)
+	}
+	if enableWebhooks {
+		if err = (&stablev1.Chart{}).SetupWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "Chart")
+			os.Exit(1)
+		}
+		if err = (&stablev1.NamespacedChart{}).SetupWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "NamespacedChart")
+			os.Exit(1)
+		}
+	}
 	// +kubebuilder:scaffold:builder
 
 	setupLog.Info("starting manager")
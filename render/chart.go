@@ -0,0 +1,64 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package render
+
+import (
+	"k8s.io/helm/pkg/chartutil"
+	"k8s.io/helm/pkg/proto/hapi/chart"
+	"k8s.io/helm/pkg/renderutil"
+	"sigs.k8s.io/yaml"
+)
+
+// Load loads a chart from a directory or a packaged archive with the chart
+// loader of helm
+func Load(chartPath string) (*chart.Chart, error) {
+	c, err := chartutil.Load(chartPath)
+	if err != nil {
+		return nil, &Error{Reason: ReasonLoadFailed, Err: err}
+	}
+	return c, nil
+}
+
+// Returns the values as the config of a release
+func valuesConfig(values map[string]interface{}) (*chart.Config, error) {
+	if values == nil {
+		values = map[string]interface{}{}
+	}
+	raw, err := yaml.Marshal(values)
+	if err != nil {
+		return nil, &Error{Reason: ReasonInvalidValues, Err: err}
+	}
+	return &chart.Config{Raw: string(raw)}, nil
+}
+
+// Drops the subcharts the values disable and imports the values of the
+// remaining ones, as helm does before rendering a chart
+func processRequirements(c *chart.Chart, config *chart.Config) error {
+	if reqs, err := chartutil.LoadRequirements(c); err == nil {
+		if err := renderutil.CheckDependencies(c, reqs); err != nil {
+			return &Error{Reason: ReasonLoadFailed, Err: err}
+		}
+	} else if err != chartutil.ErrRequirementsNotFound {
+		return &Error{Reason: ReasonLoadFailed, Err: err}
+	}
+	if err := chartutil.ProcessRequirementsEnabled(c, config); err != nil {
+		return &Error{Reason: ReasonInvalidValues, Err: err}
+	}
+	if err := chartutil.ProcessRequirementsImportValues(c); err != nil {
+		return &Error{Reason: ReasonInvalidValues, Err: err}
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package render
+
+import (
+	"bytes"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"k8s.io/helm/pkg/proto/hapi/chart"
+)
+
+// Directory of a chart holding CRDs, its files are not templated
+const crdsDir = "crds/"
+
+// Gathers the CRD files of a chart and its subcharts
+func collectCRDs(c *chart.Chart, base string, crds map[string][]byte) {
+	for _, sub := range c.Dependencies {
+		collectCRDs(sub, path.Join(base, "charts", sub.Metadata.Name), crds)
+	}
+	for _, f := range c.Files {
+		if !strings.HasPrefix(f.TypeUrl, crdsDir) {
+			continue
+		}
+		switch path.Ext(f.TypeUrl) {
+		case ".yaml", ".yml", ".json":
+			crds[path.Join(base, f.TypeUrl)] = f.Value
+		}
+	}
+}
+
+// Writes the CRD files of a chart as they are, in lexical order of their path
+// and each preceded by a `# Source:` comment. Subcharts disabled by the values
+// have to be dropped first
+func writeCRDs(out *bytes.Buffer, c *chart.Chart) {
+	crds := map[string][]byte{}
+	collectCRDs(c, c.Metadata.Name, crds)
+	names := make([]string, 0, len(crds))
+	for name := range crds {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		// a file may hold several CRDs, keep them as separate documents
+		for _, doc := range strings.Split(string(crds[name]), "\n---") {
+			doc = strings.TrimPrefix(strings.TrimSpace(doc), "---")
+			if strings.TrimSpace(doc) == "" {
+				continue
+			}
+			fmt.Fprintf(out, "---\n# Source: %s\n%s\n", name, strings.TrimSpace(doc))
+		}
+	}
+}
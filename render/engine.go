@@ -0,0 +1,109 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package render
+
+import (
+	"bytes"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/version"
+	"k8s.io/helm/pkg/chartutil"
+	"k8s.io/helm/pkg/engine"
+	"k8s.io/helm/pkg/proto/hapi/chart"
+	"k8s.io/helm/pkg/timeconv"
+	helmversion "k8s.io/helm/pkg/version"
+)
+
+// Engine renders charts in-process with the template engine of helm, the one
+// `helm template` uses
+type Engine struct {
+	// Kubernetes version exposed as .Capabilities.KubeVersion
+	KubeVersion *version.Info
+}
+
+// NewEngine returns an in-process renderer
+func NewEngine() *Engine {
+	return &Engine{
+		KubeVersion: &version.Info{Major: "1", Minor: "14", GitVersion: "v1.14.0"},
+	}
+}
+
+// Render loads the chart at chartPath and renders it
+func (e *Engine) Render(chartPath string, opts Options) ([]byte, error) {
+	c, err := Load(chartPath)
+	if err != nil {
+		return nil, err
+	}
+	return e.RenderChart(c, opts)
+}
+
+// RenderChart renders a loaded chart, dropping the subcharts its values
+// disable. The CRDs of the chart come first and templates are written in
+// lexical order of their path, each preceded by a `# Source:` comment
+func (e *Engine) RenderChart(c *chart.Chart, opts Options) ([]byte, error) {
+	config, err := valuesConfig(opts.Values)
+	if err != nil {
+		return nil, err
+	}
+	if err := processRequirements(c, config); err != nil {
+		return nil, err
+	}
+	caps := &chartutil.Capabilities{
+		APIVersions:   chartutil.NewVersionSet(append([]string{"v1"}, opts.APIVersions...)...),
+		KubeVersion:   e.KubeVersion,
+		TillerVersion: helmversion.GetVersionProto(),
+	}
+	values, err := chartutil.ToRenderValuesCaps(c, config, chartutil.ReleaseOptions{
+		Name:      opts.ReleaseName,
+		Namespace: opts.Namespace,
+		IsInstall: !opts.IsUpgrade,
+		IsUpgrade: opts.IsUpgrade,
+		Revision:  1,
+		Time:      timeconv.Now(),
+	}, caps)
+	if err != nil {
+		return nil, &Error{Reason: ReasonInvalidValues, Err: err}
+	}
+
+	renderer := engine.New()
+	// lookup needs a cluster, like `helm template` it finds nothing
+	renderer.FuncMap["lookup"] = func(apiVersion, kind, namespace, name string) (map[string]interface{}, error) {
+		return map[string]interface{}{}, nil
+	}
+	rendered, err := renderer.Render(c, values)
+	if err != nil {
+		return nil, &Error{Reason: ReasonTemplateFailed, Err: err}
+	}
+
+	var out bytes.Buffer
+	writeCRDs(&out, c)
+	names := make([]string, 0, len(rendered))
+	for name := range rendered {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		manifest := rendered[name]
+		if path.Base(name) == "NOTES.txt" || strings.TrimSpace(manifest) == "" {
+			continue
+		}
+		fmt.Fprintf(&out, "---\n# Source: %s\n%s\n", name, manifest)
+	}
+	return out.Bytes(), nil
+}
@@ -0,0 +1,155 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package render
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"k8s.io/helm/pkg/chartutil"
+	"k8s.io/helm/pkg/proto/hapi/chart"
+)
+
+// Packages a chart directory the way `helm package` does
+func packageChart(dir string) []byte {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	base := filepath.Base(dir)
+	err := filepath.Walk(dir, func(name string, fi os.FileInfo, err error) error {
+		Expect(err).NotTo(HaveOccurred())
+		if fi.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, name)
+		Expect(err).NotTo(HaveOccurred())
+		data, err := ioutil.ReadFile(name)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(tw.WriteHeader(&tar.Header{
+			Name:     filepath.ToSlash(filepath.Join(base, rel)),
+			Mode:     0644,
+			Size:     int64(len(data)),
+			Typeflag: tar.TypeReg,
+		})).To(Succeed())
+		_, err = tw.Write(data)
+		return err
+	})
+	Expect(err).NotTo(HaveOccurred())
+	Expect(tw.Close()).To(Succeed())
+	Expect(gz.Close()).To(Succeed())
+	return buf.Bytes()
+}
+
+var _ = Describe("Engine", func() {
+	var opts Options
+
+	BeforeEach(func() {
+		opts = Options{
+			ReleaseName: "foo",
+			Namespace:   "bar",
+			Values: map[string]interface{}{
+				"image": map[string]interface{}{"tag": "1.16"},
+			},
+		}
+	})
+
+	It("should render a chart directory", func() {
+		out, err := NewEngine().Render(filepath.Join("testdata", "mychart"), opts)
+		Expect(err).NotTo(HaveOccurred())
+		manifest := string(out)
+
+		By("using helpers, release and chart metadata")
+		Expect(manifest).To(ContainSubstring("# Source: mychart/templates/configmap.yaml"))
+		Expect(manifest).To(ContainSubstring("name: foo-mychart"))
+		Expect(manifest).To(ContainSubstring(`chart: "mychart-0.1.0"`))
+		Expect(manifest).To(ContainSubstring("namespace: bar"))
+
+		By("merging the values over the chart defaults")
+		Expect(manifest).To(ContainSubstring(`replicas: "1"`))
+		Expect(manifest).To(ContainSubstring(`image: "nginx:1.16"`))
+
+		By("exposing the chart files")
+		Expect(manifest).To(ContainSubstring("greeting=hello"))
+
+		By("rendering enabled subcharts under their alias")
+		Expect(manifest).To(ContainSubstring("# Source: mychart/charts/backend/templates/service.yaml"))
+		Expect(manifest).To(ContainSubstring(`backend: "8080"`))
+		Expect(manifest).To(ContainSubstring("env: test"))
+		Expect(manifest).NotTo(ContainSubstring("name: disabled"))
+
+		By("skipping notes and empty templates")
+		Expect(manifest).NotTo(ContainSubstring("Thank you"))
+		Expect(manifest).NotTo(ContainSubstring("empty.yaml"))
+	})
+
+	It("should render a packaged chart", func() {
+		c, err := chartutil.LoadArchive(bytes.NewReader(packageChart(filepath.Join("testdata", "mychart"))))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(c.Metadata.Name).To(Equal("mychart"))
+		Expect(c.Dependencies).To(HaveLen(2))
+
+		out, err := NewEngine().RenderChart(c, opts)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(out)).To(ContainSubstring("name: foo-mychart"))
+	})
+
+	It("should enable subcharts through their condition", func() {
+		opts.Values["disabled"] = map[string]interface{}{"enabled": true}
+		out, err := NewEngine().Render(filepath.Join("testdata", "mychart"), opts)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(out)).To(ContainSubstring("name: disabled"))
+	})
+
+	It("should write the CRDs of enabled charts first without templating them", func() {
+		out, err := NewEngine().Render(filepath.Join("testdata", "mychart"), opts)
+		Expect(err).NotTo(HaveOccurred())
+		manifest := string(out)
+		Expect(manifest).To(HavePrefix("---\n# Source: mychart/crds/widgets.yaml\n"))
+		Expect(manifest).To(ContainSubstring(`description: "{{ .Values.notTemplated }}"`))
+		Expect(strings.Count(manifest, "# Source: mychart/crds/widgets.yaml")).To(Equal(2))
+		Expect(manifest).NotTo(ContainSubstring("gadgets.example.com"))
+
+		opts.Values["disabled"] = map[string]interface{}{"enabled": true}
+		out, err = NewEngine().Render(filepath.Join("testdata", "mychart"), opts)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(out)).To(ContainSubstring("# Source: mychart/charts/disabled/crds/gadgets.yaml"))
+	})
+
+	It("should report template errors", func() {
+		c := &chart.Chart{
+			Metadata: &chart.Metadata{Name: "broken", Version: "0.1.0"},
+			Templates: []*chart.Template{
+				{Name: "templates/cm.yaml", Data: []byte(`{{ required "name is required" .Values.name }}`)},
+			},
+		}
+		_, err := NewEngine().RenderChart(c, opts)
+		Expect(err).To(HaveOccurred())
+		Expect(ReasonFor(err)).To(Equal(ReasonTemplateFailed))
+		Expect(err.Error()).To(ContainSubstring("name is required"))
+	})
+
+	It("should report charts that cannot be loaded", func() {
+		_, err := NewEngine().Render(filepath.Join("testdata", "missing"), opts)
+		Expect(ReasonFor(err)).To(Equal(ReasonLoadFailed))
+	})
+})
@@ -0,0 +1,49 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package render
+
+import "fmt"
+
+// Reason is a machine readable explanation of why rendering failed
+type Reason string
+
+const (
+	// The chart could not be read or is not a valid chart
+	ReasonLoadFailed Reason = "ChartLoadFailed"
+	// The supplied values could not be applied to the chart
+	ReasonInvalidValues Reason = "InvalidValues"
+	// A template failed to parse or execute
+	ReasonTemplateFailed Reason = "TemplateFailed"
+)
+
+// Error is returned by renderers so callers can report why a chart failed
+type Error struct {
+	Reason Reason
+	Err    error
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s: %v", e.Reason, e.Err)
+}
+
+// ReasonFor returns the reason of a render error, or an empty reason for
+// errors that did not come from a renderer
+func ReasonFor(err error) Reason {
+	if e, ok := err.(*Error); ok {
+		return e.Reason
+	}
+	return ""
+}
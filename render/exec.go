@@ -0,0 +1,111 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package render
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"k8s.io/helm/pkg/chartutil"
+	"sigs.k8s.io/yaml"
+)
+
+// Exec renders charts by running `helm template`, it is kept as a fallback
+// for charts that rely on behaviour of a specific helm release
+type Exec struct {
+	// Path to the helm binary, defaults to helm on the PATH
+	Binary string
+}
+
+// Render runs `helm template` against the chart at chartPath
+func (e *Exec) Render(chartPath string, opts Options) ([]byte, error) {
+	values, err := yaml.Marshal(opts.Values)
+	if err != nil {
+		return nil, &Error{Reason: ReasonInvalidValues, Err: err}
+	}
+	valuesFile, err := ioutil.TempFile("", "values-*.yaml")
+	if err != nil {
+		return nil, &Error{Reason: ReasonTemplateFailed, Err: err}
+	}
+	defer os.Remove(valuesFile.Name())
+	if _, err := valuesFile.Write(values); err != nil {
+		valuesFile.Close()
+		return nil, &Error{Reason: ReasonTemplateFailed, Err: err}
+	}
+	if err := valuesFile.Close(); err != nil {
+		return nil, &Error{Reason: ReasonTemplateFailed, Err: err}
+	}
+
+	// helm template does not know about crds/, so they are written out here
+	c, err := Load(chartPath)
+	if err != nil {
+		return nil, err
+	}
+	name := c.Metadata.Name
+	config, err := valuesConfig(opts.Values)
+	if err != nil {
+		return nil, err
+	}
+	if err := processRequirements(c, config); err != nil {
+		return nil, err
+	}
+	var out bytes.Buffer
+	writeCRDs(&out, c)
+
+	// helm template only accepts chart directories
+	if fi, err := os.Stat(chartPath); err == nil && !fi.IsDir() {
+		dir, err := ioutil.TempDir("", "chart-")
+		if err != nil {
+			return nil, &Error{Reason: ReasonLoadFailed, Err: err}
+		}
+		defer os.RemoveAll(dir)
+		if err := chartutil.ExpandFile(dir, chartPath); err != nil {
+			return nil, &Error{Reason: ReasonLoadFailed, Err: err}
+		}
+		chartPath = filepath.Join(dir, name)
+	}
+
+	binary := e.Binary
+	if binary == "" {
+		binary = "helm"
+	}
+	args := []string{
+		"template",
+		"--name=" + opts.ReleaseName,
+		"--namespace=" + opts.Namespace,
+		"--values=" + valuesFile.Name(),
+	}
+	for _, v := range opts.APIVersions {
+		args = append(args, "--api-versions="+v)
+	}
+	args = append(args, chartPath)
+	cmd := exec.Command(binary, args...)
+	var stderr bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, &Error{
+			Reason: ReasonTemplateFailed,
+			Err:    fmt.Errorf("%v: %s", err, strings.TrimSpace(stderr.String())),
+		}
+	}
+	return out.Bytes(), nil
+}
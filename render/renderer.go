@@ -0,0 +1,38 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package render turns Helm charts into plain Kubernetes manifests
+package render
+
+// Renderer renders the chart found at a local path (a chart directory or a
+// packaged .tgz archive) into a multi document YAML manifest, the files in
+// the crds/ directories of the chart and its subcharts come first, untemplated
+type Renderer interface {
+	Render(chartPath string, opts Options) ([]byte, error)
+}
+
+// Options holds the release information passed to the chart templates
+type Options struct {
+	// Name of the release, exposed as .Release.Name
+	ReleaseName string
+	// Namespace the release is installed into, exposed as .Release.Namespace
+	Namespace string
+	// Values merged over the defaults of the chart
+	Values map[string]interface{}
+	// Group versions served by the cluster, exposed as .Capabilities.APIVersions
+	APIVersions []string
+	// Whether this is an upgrade of an existing release
+	IsUpgrade bool
+}
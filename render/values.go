@@ -0,0 +1,176 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package render
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// CoalesceValues merges the defaults of a chart under the given values and
+// returns the result, values explicitly set to null remove the default
+func CoalesceValues(values, defaults map[string]interface{}) map[string]interface{} {
+	out := copyValues(values)
+	for key, def := range defaults {
+		v, ok := out[key]
+		if !ok {
+			out[key] = runtime.DeepCopyJSONValue(def)
+			continue
+		}
+		if v == nil {
+			delete(out, key)
+			continue
+		}
+		vm, vok := v.(map[string]interface{})
+		dm, dok := def.(map[string]interface{})
+		if vok && dok {
+			out[key] = CoalesceValues(vm, dm)
+		}
+	}
+	return out
+}
+
+// Returns a deep copy of a values map
+func copyValues(values map[string]interface{}) map[string]interface{} {
+	if values == nil {
+		return map[string]interface{}{}
+	}
+	return runtime.DeepCopyJSONValue(values).(map[string]interface{})
+}
+
+// Largest list index a path may use, like the --set parser of helm. Lists
+// are grown up to the index, so an unbounded one could exhaust the memory of
+// the operator
+const maxIndex = 65536
+
+// ValidatePath checks a path has the syntax SetValue accepts
+func ValidatePath(path string) error {
+	_, err := splitPath(path)
+	return err
+}
+
+// SetValue sets value at a path in values, the path uses the same syntax as
+// `helm --set` keys: dot separated names with optional list indexes such as
+// servers[0].port
+func SetValue(values map[string]interface{}, path string, value interface{}) error {
+	keys, err := splitPath(path)
+	if err != nil {
+		return &Error{Reason: ReasonInvalidValues, Err: err}
+	}
+	var current interface{} = values
+	set := func(v interface{}) {}
+	for i, k := range keys {
+		last := i == len(keys)-1
+		switch key := k.(type) {
+		case string:
+			m, ok := current.(map[string]interface{})
+			if !ok {
+				m = map[string]interface{}{}
+				set(m)
+			}
+			if last {
+				m[key] = value
+				return nil
+			}
+			current = m[key]
+			set = func(v interface{}) { m[key] = v }
+		case int:
+			l, _ := current.([]interface{})
+			for len(l) <= key {
+				l = append(l, nil)
+			}
+			set(l)
+			if last {
+				l[key] = value
+				return nil
+			}
+			current = l[key]
+			set = func(v interface{}) { l[key] = v }
+		}
+	}
+	return nil
+}
+
+// Splits a --set style key into map keys (strings) and list indexes (ints),
+// a backslash escapes the next character
+func splitPath(path string) ([]interface{}, error) {
+	var keys []interface{}
+	var name strings.Builder
+	flush := func() {
+		if name.Len() > 0 {
+			keys = append(keys, name.String())
+			name.Reset()
+		}
+	}
+	for i := 0; i < len(path); i++ {
+		switch c := path[i]; c {
+		case '\\':
+			if i+1 < len(path) {
+				i++
+				name.WriteByte(path[i])
+			}
+		case '.':
+			flush()
+		case '[':
+			flush()
+			end := strings.IndexByte(path[i:], ']')
+			if end < 0 {
+				return nil, fmt.Errorf("key %q has an unterminated list index", path)
+			}
+			index, err := strconv.Atoi(path[i+1 : i+end])
+			if err != nil || index < 0 {
+				return nil, fmt.Errorf("key %q has an invalid list index", path)
+			}
+			if index > maxIndex {
+				return nil, fmt.Errorf("key %q has a list index above the limit of %d", path, maxIndex)
+			}
+			keys = append(keys, index)
+			i += end
+		default:
+			name.WriteByte(c)
+		}
+	}
+	flush()
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("key %q is empty", path)
+	}
+	if _, ok := keys[0].(string); !ok {
+		return nil, fmt.Errorf("key %q must start with a name", path)
+	}
+	return keys, nil
+}
+
+// ParseLiteral converts a --set value into the type helm would infer for it
+func ParseLiteral(s string) interface{} {
+	switch {
+	case strings.EqualFold(s, "true"):
+		return true
+	case strings.EqualFold(s, "false"):
+		return false
+	case strings.EqualFold(s, "null"):
+		return nil
+	}
+	// keep values such as "0755" as strings
+	if s == "0" || !strings.HasPrefix(s, "0") {
+		if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return i
+		}
+	}
+	return s
+}
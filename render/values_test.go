@@ -0,0 +1,71 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package render
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Values", func() {
+
+	It("should set nested keys and list indexes", func() {
+		values := map[string]interface{}{}
+		Expect(SetValue(values, "controller.name", "foo")).To(Succeed())
+		Expect(SetValue(values, "servers[1].port", int64(80))).To(Succeed())
+		Expect(SetValue(values, `annotations.example\.com/name`, "bar")).To(Succeed())
+		Expect(values).To(Equal(map[string]interface{}{
+			"controller": map[string]interface{}{"name": "foo"},
+			"servers": []interface{}{
+				nil,
+				map[string]interface{}{"port": int64(80)},
+			},
+			"annotations": map[string]interface{}{"example.com/name": "bar"},
+		}))
+	})
+
+	It("should reject malformed keys", func() {
+		Expect(SetValue(map[string]interface{}{}, "servers[x]", "a")).NotTo(Succeed())
+		Expect(SetValue(map[string]interface{}{}, "servers[0", "a")).NotTo(Succeed())
+		Expect(SetValue(map[string]interface{}{}, "", "a")).NotTo(Succeed())
+		Expect(SetValue(map[string]interface{}{}, "servers[999999999]", "a")).NotTo(Succeed())
+		Expect(ValidatePath("servers[65537]")).NotTo(Succeed())
+		Expect(ValidatePath("servers[65536]")).To(Succeed())
+	})
+
+	It("should infer types like helm --set", func() {
+		Expect(ParseLiteral("true")).To(Equal(true))
+		Expect(ParseLiteral("4")).To(Equal(int64(4)))
+		Expect(ParseLiteral("0755")).To(Equal("0755"))
+		Expect(ParseLiteral("null")).To(BeNil())
+		Expect(ParseLiteral("foo")).To(Equal("foo"))
+	})
+
+	It("should coalesce values over defaults", func() {
+		defaults := map[string]interface{}{
+			"image":   map[string]interface{}{"repository": "nginx", "tag": "stable"},
+			"removed": "yes",
+		}
+		values := map[string]interface{}{
+			"image":   map[string]interface{}{"tag": "1.16"},
+			"removed": nil,
+		}
+		Expect(CoalesceValues(values, defaults)).To(Equal(map[string]interface{}{
+			"image": map[string]interface{}{"repository": "nginx", "tag": "1.16"},
+		}))
+		Expect(defaults["image"]).To(HaveKeyWithValue("tag", "stable"))
+	})
+})
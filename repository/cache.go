@@ -0,0 +1,73 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package repository
+
+import "sync"
+
+// Entry is the last fetched index of a repository together with the client
+// configured to download charts from it, OCI registries have no index
+type Entry struct {
+	URL    string
+	Client *Client
+	Index  *IndexFile
+}
+
+// FetchChart resolves a chart version in the cached index and returns the
+// path of its archive
+func (e *Entry) FetchChart(name, version string) (string, error) {
+	if IsOCI(e.URL) {
+		return e.Client.FetchChart(e.URL, name, version)
+	}
+	cv, err := e.Index.Get(name, version)
+	if err != nil {
+		return "", err
+	}
+	return e.Client.Download(e.URL, cv)
+}
+
+// Cache holds the indexes of named repositories so they are fetched once
+// and shared by every chart referencing them
+type Cache struct {
+	mu      sync.RWMutex
+	entries map[string]*Entry
+}
+
+// NewCache returns an empty cache
+func NewCache() *Cache {
+	return &Cache{entries: map[string]*Entry{}}
+}
+
+// Get returns the entry of a repository
+func (c *Cache) Get(name string) (*Entry, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	e, ok := c.entries[name]
+	return e, ok
+}
+
+// Set stores the entry of a repository
+func (c *Cache) Set(name string, e *Entry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[name] = e
+}
+
+// Delete removes the entry of a repository
+func (c *Cache) Delete(name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, name)
+}
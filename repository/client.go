@@ -0,0 +1,228 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package repository
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Repositories that can be referenced by name instead of URL
+var wellKnown = map[string]string{
+	"stable":    "https://kubernetes-charts.storage.googleapis.com",
+	"incubator": "https://kubernetes-charts-incubator.storage.googleapis.com",
+}
+
+// IsWellKnown reports whether the repository is referenced by name
+func IsWellKnown(repo string) bool {
+	_, ok := wellKnown[repo]
+	return ok
+}
+
+// ResolveURL returns the URL of a repository, the names stable and
+// incubator are accepted for the public repositories and an empty
+// repository means stable
+func ResolveURL(repo string) string {
+	if repo == "" {
+		repo = "stable"
+	}
+	if u, ok := wellKnown[repo]; ok {
+		return u
+	}
+	return strings.TrimSuffix(repo, "/")
+}
+
+// Client downloads repository indexes and chart archives
+type Client struct {
+	// HTTP client used for all requests, defaults to http.DefaultClient
+	HTTPClient *http.Client
+	// Directory downloaded archives are kept in
+	CacheDir string
+	// Basic auth credentials sent with every request, if set
+	Username string
+	Password string
+}
+
+// NewHTTPClient returns an HTTP client that trusts the PEM encoded CA bundle
+// in addition to the system roots
+func NewHTTPClient(caBundle []byte) (*http.Client, error) {
+	if len(caBundle) == 0 {
+		return http.DefaultClient, nil
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil || pool == nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(caBundle) {
+		return nil, fmt.Errorf("no certificates found in CA bundle")
+	}
+	return &http.Client{
+		Transport: &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: &tls.Config{RootCAs: pool},
+		},
+	}, nil
+}
+
+// FetchIndex downloads and parses the index of a repository
+func (c *Client) FetchIndex(repoURL string) (*IndexFile, error) {
+	repoURL = ResolveURL(repoURL)
+	data, err := c.get(repoURL, repoURL+"/index.yaml")
+	if err != nil {
+		return nil, err
+	}
+	return LoadIndex(data)
+}
+
+// FetchChart resolves a chart version in the repository index and returns
+// the path of its archive, archives already in the cache are not downloaded
+// again. Repositories prefixed with oci:// are pulled from an OCI registry
+func (c *Client) FetchChart(repoURL, name, version string) (string, error) {
+	if IsOCI(repoURL) {
+		return c.pullOCI(repoURL, name, version)
+	}
+	index, err := c.FetchIndex(repoURL)
+	if err != nil {
+		return "", err
+	}
+	cv, err := index.Get(name, version)
+	if err != nil {
+		return "", err
+	}
+	return c.Download(repoURL, cv)
+}
+
+// Download stores the archive of a chart version in the cache and returns
+// its path
+func (c *Client) Download(repoURL string, cv *ChartVersion) (string, error) {
+	repoURL = ResolveURL(repoURL)
+	dir := filepath.Join(c.CacheDir, digest([]byte(repoURL))[:12])
+	name, err := archiveName(cv.Name, cv.Version)
+	if err != nil {
+		return "", &Error{Reason: ReasonInvalidIndex, Err: err}
+	}
+	archive := filepath.Join(dir, name)
+	if data, err := ioutil.ReadFile(archive); err == nil {
+		if cv.Digest == "" || cv.Digest == digest(data) {
+			return archive, nil
+		}
+	}
+
+	if len(cv.URLs) == 0 {
+		return "", &Error{Reason: ReasonChartNotFound, Err: fmt.Errorf("chart %q version %q has no download URL", cv.Name, cv.Version)}
+	}
+	chartURL, err := resolveReference(repoURL, cv.URLs[0])
+	if err != nil {
+		return "", &Error{Reason: ReasonFetchFailed, Err: err}
+	}
+	data, err := c.get(repoURL, chartURL)
+	if err != nil {
+		return "", err
+	}
+	if cv.Digest != "" && cv.Digest != digest(data) {
+		return "", &Error{Reason: ReasonDigestMismatch, Err: fmt.Errorf("%s does not match digest %s", chartURL, cv.Digest)}
+	}
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return "", &Error{Reason: ReasonFetchFailed, Err: err}
+	}
+	if err := ioutil.WriteFile(archive, data, 0644); err != nil {
+		return "", &Error{Reason: ReasonFetchFailed, Err: err}
+	}
+	return archive, nil
+}
+
+// Returns the file name a chart version is cached under. Names and versions
+// come from the index of the repository or the reference of an OCI chart,
+// those that could point outside the cache are rejected
+func archiveName(name, version string) (string, error) {
+	for _, s := range []string{name, version} {
+		if s == "" || strings.ContainsAny(s, `/\`) || strings.Contains(s, "..") {
+			return "", fmt.Errorf("chart %q version %q is not a valid file name", name, version)
+		}
+	}
+	return fmt.Sprintf("%s-%s.tgz", name, version), nil
+}
+
+// Performs a GET request and returns the body. The credentials are only
+// sent to the scheme and host of the repository, charts the index points
+// elsewhere are fetched without them
+func (c *Client) get(repoURL, u string) ([]byte, error) {
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return nil, &Error{Reason: ReasonFetchFailed, Err: err}
+	}
+	if (c.Username != "" || c.Password != "") && sameOrigin(repoURL, req.URL) {
+		req.SetBasicAuth(c.Username, c.Password)
+	}
+	resp, err := c.httpClient().Do(req)
+	if err != nil {
+		return nil, &Error{Reason: ReasonFetchFailed, Err: err}
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, &Error{Reason: ReasonFetchFailed, Err: fmt.Errorf("GET %s: %s", u, resp.Status)}
+	}
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, &Error{Reason: ReasonFetchFailed, Err: err}
+	}
+	return data, nil
+}
+
+// Checks a URL has the scheme and host of the repository
+func sameOrigin(repoURL string, u *url.URL) bool {
+	repo, err := url.Parse(repoURL)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(repo.Scheme, u.Scheme) && strings.EqualFold(repo.Host, u.Host)
+}
+
+// Returns the configured HTTP client or the default one
+func (c *Client) httpClient() *http.Client {
+	if c.HTTPClient != nil {
+		return c.HTTPClient
+	}
+	return http.DefaultClient
+}
+
+// Resolves a chart URL from the index, which may be relative to the repository
+func resolveReference(repoURL, ref string) (string, error) {
+	base, err := url.Parse(repoURL + "/")
+	if err != nil {
+		return "", err
+	}
+	r, err := url.Parse(ref)
+	if err != nil {
+		return "", err
+	}
+	return base.ResolveReference(r).String(), nil
+}
+
+// Returns the hex encoded sha256 of data
+func digest(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,180 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package repository
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+
+	"github.com/Spazzy757/helm-operator/render"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// Builds a chart archive containing only a Chart.yaml
+func chartArchive(name, version string) []byte {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	data := []byte(fmt.Sprintf("name: %s\nversion: %s\n", name, version))
+	Expect(tw.WriteHeader(&tar.Header{
+		Name:     name + "/Chart.yaml",
+		Mode:     0644,
+		Size:     int64(len(data)),
+		Typeflag: tar.TypeReg,
+	})).To(Succeed())
+	_, err := tw.Write(data)
+	Expect(err).NotTo(HaveOccurred())
+	Expect(tw.Close()).To(Succeed())
+	Expect(gz.Close()).To(Succeed())
+	return buf.Bytes()
+}
+
+var _ = Describe("Client", func() {
+	var (
+		server   *httptest.Server
+		client   *Client
+		archive  []byte
+		requests int
+		mirror   string
+		auth     map[string]string
+	)
+
+	BeforeEach(func() {
+		archive = chartArchive("nginx", "1.1.0")
+		requests = 0
+		mirror = "http://mirror.example.com"
+		auth = map[string]string{}
+		mux := http.NewServeMux()
+		mux.HandleFunc("/index.yaml", func(w http.ResponseWriter, r *http.Request) {
+			auth[r.URL.Path] = r.Header.Get("Authorization")
+			fmt.Fprintf(w, `apiVersion: v1
+entries:
+  mirrored:
+  - name: mirrored
+    version: 0.1.0
+    urls:
+    - %s/mirrored-0.1.0.tgz
+  nginx:
+  - name: nginx
+    version: 1.1.0
+    digest: %s
+    urls:
+    - charts/nginx-1.1.0.tgz
+  - name: nginx
+    version: 1.0.0
+    digest: 0000
+    urls:
+    - charts/nginx-1.0.0.tgz
+`, mirror, digest(archive))
+		})
+		mux.HandleFunc("/charts/nginx-1.1.0.tgz", func(w http.ResponseWriter, r *http.Request) {
+			auth[r.URL.Path] = r.Header.Get("Authorization")
+			requests++
+			w.Write(archive)
+		})
+		mux.HandleFunc("/charts/nginx-1.0.0.tgz", func(w http.ResponseWriter, r *http.Request) {
+			w.Write(archive)
+		})
+		server = httptest.NewServer(mux)
+
+		dir, err := ioutil.TempDir("", "repository-test")
+		Expect(err).NotTo(HaveOccurred())
+		client = &Client{CacheDir: dir}
+	})
+
+	AfterEach(func() {
+		server.Close()
+		os.RemoveAll(client.CacheDir)
+	})
+
+	It("should download a chart version listed in the index", func() {
+		chartPath, err := client.FetchChart(server.URL, "nginx", "1.1.0")
+		Expect(err).NotTo(HaveOccurred())
+
+		c, err := render.Load(chartPath)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(c.Metadata.Version).To(Equal("1.1.0"))
+	})
+
+	It("should reuse archives from the cache", func() {
+		_, err := client.FetchChart(server.URL+"/", "nginx", "1.1.0")
+		Expect(err).NotTo(HaveOccurred())
+		_, err = client.FetchChart(server.URL, "nginx", "v1.1.0")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(requests).To(Equal(1))
+	})
+
+	It("should report missing charts and versions", func() {
+		_, err := client.FetchChart(server.URL, "redis", "1.1.0")
+		Expect(ReasonFor(err)).To(Equal(ReasonChartNotFound))
+		_, err = client.FetchChart(server.URL, "nginx", "2.0.0")
+		Expect(ReasonFor(err)).To(Equal(ReasonVersionNotFound))
+	})
+
+	It("should reject archives that do not match their digest", func() {
+		_, err := client.FetchChart(server.URL, "nginx", "1.0.0")
+		Expect(ReasonFor(err)).To(Equal(ReasonDigestMismatch))
+	})
+
+	It("should reject chart versions that would be cached outside the cache", func() {
+		for _, cv := range []*ChartVersion{
+			{Name: "../../etc", Version: "1.0.0", URLs: []string{"charts/nginx-1.1.0.tgz"}},
+			{Name: "nginx", Version: "1.0.0/../../x", URLs: []string{"charts/nginx-1.1.0.tgz"}},
+		} {
+			_, err := client.Download(server.URL, cv)
+			Expect(ReasonFor(err)).To(Equal(ReasonInvalidIndex))
+		}
+		Expect(requests).To(BeZero())
+	})
+
+	It("should only send credentials to the repository", func() {
+		other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			auth[r.URL.Path] = r.Header.Get("Authorization")
+			w.Write(chartArchive("mirrored", "0.1.0"))
+		}))
+		defer other.Close()
+		mirror = other.URL
+		client.Username, client.Password = "user", "secret"
+
+		_, err := client.FetchChart(server.URL, "nginx", "1.1.0")
+		Expect(err).NotTo(HaveOccurred())
+		_, err = client.FetchChart(server.URL, "mirrored", "0.1.0")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(auth["/index.yaml"]).To(HavePrefix("Basic "))
+		Expect(auth["/charts/nginx-1.1.0.tgz"]).To(HavePrefix("Basic "))
+		Expect(auth).To(HaveKeyWithValue("/mirrored-0.1.0.tgz", ""))
+	})
+
+	It("should report unreachable repositories", func() {
+		_, err := client.FetchChart(server.URL+"/missing", "nginx", "1.1.0")
+		Expect(ReasonFor(err)).To(Equal(ReasonFetchFailed))
+	})
+})
+
+var _ = Describe("ResolveURL", func() {
+	It("should resolve well known repository names", func() {
+		Expect(ResolveURL("")).To(Equal(wellKnown["stable"]))
+		Expect(ResolveURL("incubator")).To(Equal(wellKnown["incubator"]))
+		Expect(ResolveURL("https://charts.example.com/")).To(Equal("https://charts.example.com"))
+	})
+})
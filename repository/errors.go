@@ -0,0 +1,61 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package repository
+
+import "fmt"
+
+// Reason is a machine readable explanation of why fetching a chart failed
+type Reason string
+
+const (
+	// The repository could not be reached or returned an error
+	ReasonFetchFailed Reason = "FetchFailed"
+	// The repository index could not be parsed
+	ReasonInvalidIndex Reason = "InvalidIndex"
+	// The repository does not list the chart
+	ReasonChartNotFound Reason = "ChartNotFound"
+	// The repository does not list the requested version of the chart
+	ReasonVersionNotFound Reason = "VersionNotFound"
+	// The downloaded archive does not match the digest in the index
+	ReasonDigestMismatch Reason = "DigestMismatch"
+	// The git ref does not resolve to a commit
+	ReasonRevisionNotFound Reason = "RevisionNotFound"
+	// The OCI manifest of a chart could not be parsed or has no chart layer
+	ReasonInvalidManifest Reason = "InvalidManifest"
+	// The referenced ChartRepository has not been synced yet
+	ReasonRepositoryNotReady Reason = "RepositoryNotReady"
+	// The version of the chart is neither a version nor a semver range
+	ReasonInvalidVersion Reason = "InvalidVersion"
+)
+
+// Error is returned when a chart cannot be fetched from a repository
+type Error struct {
+	Reason Reason
+	Err    error
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s: %v", e.Reason, e.Err)
+}
+
+// ReasonFor returns the reason of a repository error, or an empty reason for
+// errors that did not come from this package
+func ReasonFor(err error) Reason {
+	if e, ok := err.(*Error); ok {
+		return e.Reason
+	}
+	return ""
+}
@@ -0,0 +1,150 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package repository
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// GitClient checks charts out of git repositories, every repository is kept
+// as a mirror in the cache and fetched again on each checkout
+type GitClient struct {
+	// Path to the git binary, defaults to git on the PATH
+	Binary string
+	// Directory mirrors and checkouts are kept in
+	CacheDir string
+
+	mu sync.Mutex
+}
+
+// Checkout resolves ref in the repository at url and returns the directory
+// of path at that commit together with the full commit SHA, an empty ref
+// resolves to the default branch
+func (g *GitClient) Checkout(url, ref, chartPath string) (string, string, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	repoDir := filepath.Join(g.CacheDir, "git", digest([]byte(url))[:12])
+	mirror := filepath.Join(repoDir, "mirror")
+	if _, err := os.Stat(mirror); os.IsNotExist(err) {
+		if err := os.MkdirAll(repoDir, os.ModePerm); err != nil {
+			return "", "", &Error{Reason: ReasonFetchFailed, Err: err}
+		}
+		if _, err := g.git("", "clone", "--mirror", "--quiet", "--", url, mirror); err != nil {
+			os.RemoveAll(mirror)
+			return "", "", &Error{Reason: ReasonFetchFailed, Err: err}
+		}
+	} else if _, err := g.git(mirror, "fetch", "--prune", "--quiet"); err != nil {
+		return "", "", &Error{Reason: ReasonFetchFailed, Err: err}
+	}
+
+	if ref == "" {
+		ref = "HEAD"
+	}
+	out, err := g.git(mirror, "rev-parse", "--verify", "--quiet", "--end-of-options", ref+"^{commit}")
+	if err != nil {
+		return "", "", &Error{Reason: ReasonRevisionNotFound, Err: fmt.Errorf("ref %q not found in %s", ref, url)}
+	}
+	commit := strings.TrimSpace(string(out))
+
+	// Checkouts of a commit never change, so they are only exported once
+	checkout := filepath.Join(repoDir, commit)
+	if _, err := os.Stat(checkout); os.IsNotExist(err) {
+		if err := g.export(mirror, commit, checkout); err != nil {
+			return "", "", &Error{Reason: ReasonFetchFailed, Err: err}
+		}
+	}
+
+	clean := path.Clean("/" + chartPath)
+	dir := filepath.Join(checkout, filepath.FromSlash(clean))
+	if fi, err := os.Stat(dir); err != nil || !fi.IsDir() {
+		return "", "", &Error{Reason: ReasonChartNotFound, Err: fmt.Errorf("%s has no directory %s at %s", url, clean, commit)}
+	}
+	return dir, commit, nil
+}
+
+// Writes the tree of a commit to dir, the tree is unpacked next to dir and
+// renamed so an interrupted export is never mistaken for a checkout
+func (g *GitClient) export(mirror, commit, dir string) error {
+	archive, err := g.git(mirror, "archive", "--format=tar", commit)
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempDir(filepath.Dir(dir), "checkout-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+
+	tr := tar.NewReader(bytes.NewReader(archive))
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		name := filepath.Join(tmp, filepath.FromSlash(path.Clean("/"+hdr.Name)))
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(name, os.ModePerm); err != nil {
+				return err
+			}
+		case tar.TypeReg, tar.TypeRegA:
+			if err := os.MkdirAll(filepath.Dir(name), os.ModePerm); err != nil {
+				return err
+			}
+			data, err := ioutil.ReadAll(tr)
+			if err != nil {
+				return err
+			}
+			if err := ioutil.WriteFile(name, data, 0644); err != nil {
+				return err
+			}
+		}
+	}
+	return os.Rename(tmp, dir)
+}
+
+// Runs git in dir and returns its output
+func (g *GitClient) git(dir string, args ...string) ([]byte, error) {
+	binary := g.Binary
+	if binary == "" {
+		binary = "git"
+	}
+	cmd := exec.Command(binary, args...)
+	cmd.Dir = dir
+	// Never wait on a credential prompt
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("git %s: %v: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.Bytes(), nil
+}
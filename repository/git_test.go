@@ -0,0 +1,127 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package repository
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/Spazzy757/helm-operator/render"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// Runs git in dir with a fixed identity and returns the trimmed output
+func runGit(dir string, args ...string) string {
+	args = append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	Expect(err).NotTo(HaveOccurred(), string(out))
+	return strings.TrimSpace(string(out))
+}
+
+// Commits a chart at charts/app with the given version and pushes it
+func commitChart(work, version string) string {
+	dir := filepath.Join(work, "charts", "app")
+	Expect(os.MkdirAll(dir, os.ModePerm)).To(Succeed())
+	Expect(ioutil.WriteFile(filepath.Join(dir, "Chart.yaml"), []byte("name: app\nversion: "+version+"\n"), 0644)).To(Succeed())
+	runGit(work, "add", "-A")
+	runGit(work, "commit", "--quiet", "-m", "app "+version)
+	runGit(work, "push", "--quiet", "origin", "HEAD:master")
+	return runGit(work, "rev-parse", "HEAD")
+}
+
+var _ = Describe("GitClient", func() {
+	var (
+		tmp    string
+		bare   string
+		work   string
+		git    *GitClient
+		first  string
+		second string
+	)
+
+	BeforeEach(func() {
+		var err error
+		tmp, err = ioutil.TempDir("", "git-test")
+		Expect(err).NotTo(HaveOccurred())
+		bare = filepath.Join(tmp, "remote.git")
+		work = filepath.Join(tmp, "work")
+		runGit(tmp, "init", "--quiet", "--bare", "--initial-branch=master", bare)
+		runGit(tmp, "clone", "--quiet", bare, work)
+
+		first = commitChart(work, "0.1.0")
+		runGit(work, "tag", "v0.1.0")
+		runGit(work, "push", "--quiet", "origin", "v0.1.0")
+		second = commitChart(work, "0.2.0")
+
+		git = &GitClient{CacheDir: filepath.Join(tmp, "cache")}
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(tmp)
+	})
+
+	It("should check out the default branch", func() {
+		dir, commit, err := git.Checkout(bare, "", "charts/app")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(commit).To(Equal(second))
+
+		c, err := render.Load(dir)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(c.Metadata.Version).To(Equal("0.2.0"))
+	})
+
+	It("should resolve tags and commits", func() {
+		dir, commit, err := git.Checkout(bare, "v0.1.0", "charts/app")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(commit).To(Equal(first))
+		c, err := render.Load(dir)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(c.Metadata.Version).To(Equal("0.1.0"))
+
+		_, commit, err = git.Checkout(bare, first[:10], "/charts/app/")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(commit).To(Equal(first))
+	})
+
+	It("should fetch new commits into the mirror", func() {
+		_, commit, err := git.Checkout(bare, "master", "charts/app")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(commit).To(Equal(second))
+
+		third := commitChart(work, "0.3.0")
+		dir, commit, err := git.Checkout(bare, "master", "charts/app")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(commit).To(Equal(third))
+		c, err := render.Load(dir)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(c.Metadata.Version).To(Equal("0.3.0"))
+	})
+
+	It("should report unknown refs and paths", func() {
+		_, _, err := git.Checkout(bare, "missing", "charts/app")
+		Expect(ReasonFor(err)).To(Equal(ReasonRevisionNotFound))
+		_, _, err = git.Checkout(bare, "", "charts/missing")
+		Expect(ReasonFor(err)).To(Equal(ReasonChartNotFound))
+		_, _, err = git.Checkout(filepath.Join(tmp, "missing.git"), "", "charts/app")
+		Expect(ReasonFor(err)).To(Equal(ReasonFetchFailed))
+	})
+})
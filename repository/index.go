@@ -0,0 +1,68 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package repository fetches charts from classic index based chart repositories,
+// OCI registries and git repositories
+package repository
+
+import (
+	"fmt"
+	"strings"
+
+	"sigs.k8s.io/yaml"
+)
+
+// IndexFile is the index.yaml served at the root of a chart repository
+type IndexFile struct {
+	APIVersion string                     `json:"apiVersion"`
+	Entries    map[string][]*ChartVersion `json:"entries"`
+}
+
+// ChartVersion is a single packaged version of a chart listed in an index
+type ChartVersion struct {
+	Name        string   `json:"name"`
+	Version     string   `json:"version"`
+	AppVersion  string   `json:"appVersion,omitempty"`
+	Description string   `json:"description,omitempty"`
+	Digest      string   `json:"digest,omitempty"`
+	URLs        []string `json:"urls"`
+}
+
+// LoadIndex parses the content of an index.yaml
+func LoadIndex(data []byte) (*IndexFile, error) {
+	i := &IndexFile{}
+	if err := yaml.Unmarshal(data, i); err != nil {
+		return nil, &Error{Reason: ReasonInvalidIndex, Err: err}
+	}
+	if i.APIVersion == "" {
+		return nil, &Error{Reason: ReasonInvalidIndex, Err: fmt.Errorf("no API version specified")}
+	}
+	return i, nil
+}
+
+// Get returns the entry of a chart at an exact version, a leading "v" is
+// ignored on either side
+func (i *IndexFile) Get(name, version string) (*ChartVersion, error) {
+	versions, ok := i.Entries[name]
+	if !ok {
+		return nil, &Error{Reason: ReasonChartNotFound, Err: fmt.Errorf("chart %q not found in repository index", name)}
+	}
+	for _, cv := range versions {
+		if strings.TrimPrefix(cv.Version, "v") == strings.TrimPrefix(version, "v") {
+			return cv, nil
+		}
+	}
+	return nil, &Error{Reason: ReasonVersionNotFound, Err: fmt.Errorf("chart %q has no version %q", name, version)}
+}
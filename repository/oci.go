@@ -0,0 +1,304 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package repository
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// OCIScheme prefixes repositories that are paths in an OCI registry
+const OCIScheme = "oci://"
+
+// Media types of a chart stored as an OCI artifact
+const (
+	ociManifestMediaType    = "application/vnd.oci.image.manifest.v1+json"
+	dockerManifestMediaType = "application/vnd.docker.distribution.manifest.v2+json"
+	chartLayerMediaType     = "application/vnd.cncf.helm.chart.content.v1.tar+gzip"
+	// Used by helm releases before the media types were registered
+	legacyChartLayerMediaType = "application/tar+gzip"
+)
+
+// IsOCI reports whether the repository is a path in an OCI registry
+func IsOCI(repo string) bool {
+	return strings.HasPrefix(repo, OCIScheme)
+}
+
+// Reference to a chart in an OCI registry
+type ociReference struct {
+	Registry   string
+	Repository string
+	Tag        string
+}
+
+// Splits oci://registry/path together with the chart name and version into
+// the registry, repository and tag of the artifact
+func parseOCIReference(repoURL, name, version string) (*ociReference, error) {
+	ref := strings.Trim(strings.TrimPrefix(repoURL, OCIScheme), "/")
+	registry, path := ref, ""
+	if i := strings.Index(ref, "/"); i >= 0 {
+		registry, path = ref[:i], ref[i+1:]
+	}
+	if registry == "" || name == "" || version == "" {
+		return nil, fmt.Errorf("invalid OCI reference %s/%s:%s", repoURL, name, version)
+	}
+	repository := name
+	if path != "" {
+		repository = path + "/" + name
+	}
+	// Tags cannot contain "+", helm publishes build metadata with "_" instead
+	return &ociReference{
+		Registry:   registry,
+		Repository: repository,
+		Tag:        strings.Replace(version, "+", "_", -1),
+	}, nil
+}
+
+func (r *ociReference) String() string {
+	return r.Registry + "/" + r.Repository + ":" + r.Tag
+}
+
+type ociManifest struct {
+	Layers []ociDescriptor `json:"layers"`
+}
+
+type ociDescriptor struct {
+	MediaType string `json:"mediaType"`
+	Digest    string `json:"digest"`
+	Size      int64  `json:"size"`
+}
+
+// Pulls the chart layer of an OCI artifact into the cache and returns its path
+func (c *Client) pullOCI(repoURL, name, version string) (string, error) {
+	ref, err := parseOCIReference(repoURL, name, version)
+	if err != nil {
+		return "", &Error{Reason: ReasonChartNotFound, Err: err}
+	}
+	s := &registrySession{client: c}
+	base := "https://" + ref.Registry + "/v2/" + ref.Repository
+
+	data, status, err := s.get(base+"/manifests/"+url.PathEscape(ref.Tag), ociManifestMediaType+", "+dockerManifestMediaType)
+	if err != nil {
+		return "", err
+	}
+	if status == http.StatusNotFound {
+		return "", &Error{Reason: ReasonChartNotFound, Err: fmt.Errorf("chart %s not found in registry", ref)}
+	}
+	if status != http.StatusOK {
+		return "", &Error{Reason: ReasonFetchFailed, Err: fmt.Errorf("GET manifest of %s: %s", ref, http.StatusText(status))}
+	}
+	manifest := &ociManifest{}
+	if err := json.Unmarshal(data, manifest); err != nil {
+		return "", &Error{Reason: ReasonInvalidManifest, Err: err}
+	}
+	var layer *ociDescriptor
+	for i := range manifest.Layers {
+		if mt := manifest.Layers[i].MediaType; mt == chartLayerMediaType || mt == legacyChartLayerMediaType {
+			layer = &manifest.Layers[i]
+			break
+		}
+	}
+	if layer == nil || !strings.HasPrefix(layer.Digest, "sha256:") {
+		return "", &Error{Reason: ReasonInvalidManifest, Err: fmt.Errorf("%s has no sha256 chart layer", ref)}
+	}
+	want := strings.TrimPrefix(layer.Digest, "sha256:")
+
+	dir := filepath.Join(c.CacheDir, digest([]byte(ref.Registry + "/" + ref.Repository))[:12])
+	file, err := archiveName(name, version)
+	if err != nil {
+		return "", &Error{Reason: ReasonInvalidManifest, Err: err}
+	}
+	archive := filepath.Join(dir, file)
+	if data, err := ioutil.ReadFile(archive); err == nil && digest(data) == want {
+		return archive, nil
+	}
+
+	data, status, err = s.get(base+"/blobs/"+layer.Digest, "")
+	if err != nil {
+		return "", err
+	}
+	if status != http.StatusOK {
+		return "", &Error{Reason: ReasonFetchFailed, Err: fmt.Errorf("GET layer %s of %s: %s", layer.Digest, ref, http.StatusText(status))}
+	}
+	if digest(data) != want {
+		return "", &Error{Reason: ReasonDigestMismatch, Err: fmt.Errorf("layer of %s does not match digest %s", ref, layer.Digest)}
+	}
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return "", &Error{Reason: ReasonFetchFailed, Err: err}
+	}
+	if err := ioutil.WriteFile(archive, data, 0644); err != nil {
+		return "", &Error{Reason: ReasonFetchFailed, Err: err}
+	}
+	return archive, nil
+}
+
+// registrySession performs requests against a registry, answering the
+// authentication challenge of the first request and reusing the result
+type registrySession struct {
+	client        *Client
+	authorization string
+}
+
+// Performs a GET request and returns the body and status code, requests
+// rejected as unauthorized are retried once with credentials
+func (s *registrySession) get(u, accept string) ([]byte, int, error) {
+	resp, err := s.do(u, accept)
+	if err != nil {
+		return nil, 0, err
+	}
+	if resp.StatusCode == http.StatusUnauthorized && s.authorization == "" {
+		resp.Body.Close()
+		if err := s.authorize(resp.Header.Get("WWW-Authenticate")); err != nil {
+			return nil, 0, err
+		}
+		if resp, err = s.do(u, accept); err != nil {
+			return nil, 0, err
+		}
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+		return nil, 0, &Error{Reason: ReasonFetchFailed, Err: fmt.Errorf("GET %s: %s", u, resp.Status)}
+	}
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, 0, &Error{Reason: ReasonFetchFailed, Err: err}
+	}
+	return data, resp.StatusCode, nil
+}
+
+func (s *registrySession) do(u, accept string) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return nil, &Error{Reason: ReasonFetchFailed, Err: err}
+	}
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	if s.authorization != "" {
+		req.Header.Set("Authorization", s.authorization)
+	}
+	resp, err := s.client.httpClient().Do(req)
+	if err != nil {
+		return nil, &Error{Reason: ReasonFetchFailed, Err: err}
+	}
+	return resp, nil
+}
+
+// Matches the key="value" parameters of a WWW-Authenticate header
+var challengeParam = regexp.MustCompile(`(\w+)="([^"]*)"`)
+
+// Answers a Basic or Bearer authentication challenge, bearer tokens are
+// requested from the realm of the challenge with the client credentials
+func (s *registrySession) authorize(challenge string) error {
+	c := s.client
+	basic := "Basic " + base64.StdEncoding.EncodeToString([]byte(c.Username+":"+c.Password))
+	scheme := strings.ToLower(strings.SplitN(challenge, " ", 2)[0])
+	switch scheme {
+	case "basic":
+		s.authorization = basic
+		return nil
+	case "bearer":
+	default:
+		return &Error{Reason: ReasonFetchFailed, Err: fmt.Errorf("unsupported registry authentication %q", challenge)}
+	}
+
+	params := map[string]string{}
+	for _, m := range challengeParam.FindAllStringSubmatch(challenge, -1) {
+		params[m[1]] = m[2]
+	}
+	realm, err := url.Parse(params["realm"])
+	if err != nil || params["realm"] == "" {
+		return &Error{Reason: ReasonFetchFailed, Err: fmt.Errorf("invalid registry authentication realm %q", params["realm"])}
+	}
+	query := realm.Query()
+	for _, key := range []string{"service", "scope"} {
+		if params[key] != "" {
+			query.Set(key, params[key])
+		}
+	}
+	realm.RawQuery = query.Encode()
+
+	req, err := http.NewRequest(http.MethodGet, realm.String(), nil)
+	if err != nil {
+		return &Error{Reason: ReasonFetchFailed, Err: err}
+	}
+	if c.Username != "" || c.Password != "" {
+		req.Header.Set("Authorization", basic)
+	}
+	resp, err := c.httpClient().Do(req)
+	if err != nil {
+		return &Error{Reason: ReasonFetchFailed, Err: err}
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return &Error{Reason: ReasonFetchFailed, Err: fmt.Errorf("GET token %s: %s", realm.Host, resp.Status)}
+	}
+	token := struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}{}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return &Error{Reason: ReasonFetchFailed, Err: err}
+	}
+	if token.Token == "" {
+		token.Token = token.AccessToken
+	}
+	s.authorization = "Bearer " + token.Token
+	return nil
+}
+
+// CredentialsFromDockerConfig returns the username and password of the
+// registry of an OCI repository from the content of a .dockerconfigjson
+func CredentialsFromDockerConfig(data []byte, repoURL string) (string, string, error) {
+	config := struct {
+		Auths map[string]struct {
+			Username string `json:"username"`
+			Password string `json:"password"`
+			Auth     string `json:"auth"`
+		} `json:"auths"`
+	}{}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return "", "", err
+	}
+	registry := strings.SplitN(strings.TrimPrefix(repoURL, OCIScheme), "/", 2)[0]
+	for host, auth := range config.Auths {
+		host = strings.TrimPrefix(strings.TrimPrefix(host, "https://"), "http://")
+		if strings.SplitN(host, "/", 2)[0] != registry {
+			continue
+		}
+		if auth.Auth == "" {
+			return auth.Username, auth.Password, nil
+		}
+		decoded, err := base64.StdEncoding.DecodeString(auth.Auth)
+		if err != nil {
+			return "", "", err
+		}
+		parts := strings.SplitN(string(decoded), ":", 2)
+		if len(parts) != 2 {
+			return "", "", fmt.Errorf("invalid auth for registry %s", registry)
+		}
+		return parts[0], parts[1], nil
+	}
+	return "", "", fmt.Errorf("no credentials for registry %s", registry)
+}
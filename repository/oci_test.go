@@ -0,0 +1,198 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package repository
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+
+	"github.com/Spazzy757/helm-operator/render"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// A minimal distribution registry serving charts under token authentication
+type testRegistry struct {
+	*httptest.Server
+	blobs     map[string][]byte
+	manifests map[string][]byte
+	pulls     int
+}
+
+func newTestRegistry() *testRegistry {
+	r := &testRegistry{blobs: map[string][]byte{}, manifests: map[string][]byte{}}
+	r.Server = httptest.NewTLSServer(http.HandlerFunc(r.serve))
+	return r
+}
+
+func (r *testRegistry) host() string {
+	return strings.TrimPrefix(r.URL, "https://")
+}
+
+// Stores a chart archive as an OCI artifact under repository:tag
+func (r *testRegistry) push(repository, tag string, archive []byte) {
+	d := "sha256:" + digest(archive)
+	r.blobs[d] = archive
+	manifest, err := json.Marshal(map[string]interface{}{
+		"schemaVersion": 2,
+		"config":        map[string]interface{}{"mediaType": "application/vnd.cncf.helm.config.v1+json", "digest": "sha256:0", "size": 0},
+		"layers":        []map[string]interface{}{{"mediaType": chartLayerMediaType, "digest": d, "size": len(archive)}},
+	})
+	Expect(err).NotTo(HaveOccurred())
+	r.manifests[repository+":"+tag] = manifest
+}
+
+func (r *testRegistry) serve(w http.ResponseWriter, req *http.Request) {
+	if req.URL.Path == "/token" {
+		if user, pass, ok := req.BasicAuth(); !ok || user != "robot" || pass != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		fmt.Fprint(w, `{"token": "t0ken"}`)
+		return
+	}
+	if req.Header.Get("Authorization") != "Bearer t0ken" {
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="registry",scope="repository:charts:pull"`, r.URL))
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	path := strings.TrimPrefix(req.URL.Path, "/v2/")
+	if strings.HasSuffix(path, "/tags/list") {
+		repository := strings.TrimSuffix(path, "/tags/list")
+		tags := []string{}
+		for ref := range r.manifests {
+			if strings.HasPrefix(ref, repository+":") {
+				tags = append(tags, strings.TrimPrefix(ref, repository+":"))
+			}
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"name": repository, "tags": tags})
+		return
+	}
+	if i := strings.Index(path, "/manifests/"); i >= 0 {
+		manifest, ok := r.manifests[path[:i]+":"+path[i+len("/manifests/"):]]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", ociManifestMediaType)
+		w.Write(manifest)
+		return
+	}
+	if i := strings.Index(path, "/blobs/"); i >= 0 {
+		blob, ok := r.blobs[path[i+len("/blobs/"):]]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		r.pulls++
+		w.Write(blob)
+		return
+	}
+	w.WriteHeader(http.StatusNotFound)
+}
+
+var _ = Describe("OCI registries", func() {
+	var (
+		registry *testRegistry
+		client   *Client
+		repo     string
+	)
+
+	BeforeEach(func() {
+		registry = newTestRegistry()
+		registry.push("charts/nginx", "1.1.0", chartArchive("nginx", "1.1.0"))
+		registry.push("charts/nginx", "1.2.0_build.1", chartArchive("nginx", "1.2.0+build.1"))
+		repo = OCIScheme + registry.host() + "/charts"
+
+		dir, err := ioutil.TempDir("", "oci-test")
+		Expect(err).NotTo(HaveOccurred())
+		client = &Client{
+			HTTPClient: registry.Client(),
+			CacheDir:   dir,
+			Username:   "robot",
+			Password:   "secret",
+		}
+	})
+
+	AfterEach(func() {
+		registry.Close()
+		os.RemoveAll(client.CacheDir)
+	})
+
+	It("should list the tags of a chart as versions", func() {
+		versions, err := client.ListVersions(repo, "nginx")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(versions).To(ConsistOf("1.1.0", "1.2.0+build.1"))
+	})
+
+	It("should pull the chart layer with a bearer token", func() {
+		chartPath, err := client.FetchChart(repo, "nginx", "1.1.0")
+		Expect(err).NotTo(HaveOccurred())
+
+		c, err := render.Load(chartPath)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(c.Metadata.Version).To(Equal("1.1.0"))
+	})
+
+	It("should map build metadata onto the tag", func() {
+		chartPath, err := client.FetchChart(repo+"/", "nginx", "1.2.0+build.1")
+		Expect(err).NotTo(HaveOccurred())
+
+		c, err := render.Load(chartPath)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(c.Metadata.Version).To(Equal("1.2.0+build.1"))
+	})
+
+	It("should reuse layers from the cache", func() {
+		_, err := client.FetchChart(repo, "nginx", "1.1.0")
+		Expect(err).NotTo(HaveOccurred())
+		_, err = client.FetchChart(repo, "nginx", "1.1.0")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(registry.pulls).To(Equal(1))
+	})
+
+	It("should report missing charts", func() {
+		_, err := client.FetchChart(repo, "nginx", "9.9.9")
+		Expect(ReasonFor(err)).To(Equal(ReasonChartNotFound))
+	})
+
+	It("should fail without valid credentials", func() {
+		client.Password = "wrong"
+		_, err := client.FetchChart(repo, "nginx", "1.1.0")
+		Expect(ReasonFor(err)).To(Equal(ReasonFetchFailed))
+	})
+})
+
+var _ = Describe("CredentialsFromDockerConfig", func() {
+	It("should read the credentials of the registry", func() {
+		config := []byte(`{"auths": {
+			"other.example.com": {"username": "nobody", "password": "nothing"},
+			"https://registry.example.com": {"auth": "cm9ib3Q6c2VjcmV0"}
+		}}`)
+		user, pass, err := CredentialsFromDockerConfig(config, "oci://registry.example.com/charts")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(user).To(Equal("robot"))
+		Expect(pass).To(Equal("secret"))
+
+		_, _, err = CredentialsFromDockerConfig(config, "oci://missing.example.com/charts")
+		Expect(err).To(HaveOccurred())
+	})
+})
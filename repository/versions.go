@@ -0,0 +1,174 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package repository
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/Masterminds/semver"
+)
+
+// IsRange reports whether version is a semver range such as ~1.2 or
+// >=2.0 <3 rather than an exact version
+func IsRange(version string) bool {
+	if version == "" {
+		return false
+	}
+	_, err := semver.NewVersion(version)
+	return err != nil
+}
+
+// ParseRange parses a semver range, the comparisons a range requires all of
+// may be separated by spaces as well as commas
+func ParseRange(r string) (*semver.Constraints, error) {
+	// hyphen ranges such as 1.2 - 1.4 are spaced already
+	if !strings.Contains(r, " - ") {
+		var ors []string
+		for _, or := range strings.Split(r, "||") {
+			var ands []string
+			operator := ""
+			for _, f := range strings.FieldsFunc(or, func(c rune) bool { return c == ' ' || c == ',' }) {
+				// an operator separated from its version
+				if strings.Trim(f, "<>=!~^") == "" {
+					operator += f
+					continue
+				}
+				ands = append(ands, padLessThan(operator+f))
+				operator = ""
+			}
+			if operator != "" {
+				ands = append(ands, operator)
+			}
+			ors = append(ors, strings.Join(ands, ","))
+		}
+		r = strings.Join(ors, "||")
+	}
+	c, err := semver.NewConstraint(r)
+	if err != nil {
+		return nil, &Error{Reason: ReasonInvalidVersion, Err: fmt.Errorf("invalid version range %q: %v", r, err)}
+	}
+	return c, nil
+}
+
+// The semver library reads <3 as below 4, like npm and helm it means below
+// 3.0.0 here
+func padLessThan(comparison string) string {
+	version := strings.TrimLeft(comparison, "<>=!~^ ")
+	operator := strings.TrimSpace(strings.TrimSuffix(comparison, version))
+	if operator != "<" || strings.ContainsAny(version, "xX*-+") {
+		return comparison
+	}
+	core := strings.TrimPrefix(version, "v")
+	for strings.Count(core, ".") < 2 {
+		core += ".0"
+	}
+	return operator + core
+}
+
+// MatchingVersions returns the versions in a range, newest first. Versions
+// that are not semver are left out
+func MatchingVersions(versions []string, r string) ([]string, error) {
+	c, err := ParseRange(r)
+	if err != nil {
+		return nil, err
+	}
+	var matching []*semver.Version
+	originals := map[*semver.Version]string{}
+	for _, version := range versions {
+		v, err := semver.NewVersion(version)
+		if err != nil || !c.Check(v) {
+			continue
+		}
+		matching = append(matching, v)
+		originals[v] = version
+	}
+	sort.Sort(sort.Reverse(semver.Collection(matching)))
+	var sorted []string
+	for _, v := range matching {
+		sorted = append(sorted, originals[v])
+	}
+	return sorted, nil
+}
+
+// Versions returns every version of a chart listed in the index
+func (i *IndexFile) Versions(name string) ([]string, error) {
+	entries, ok := i.Entries[name]
+	if !ok {
+		return nil, &Error{Reason: ReasonChartNotFound, Err: fmt.Errorf("chart %q not found in repository index", name)}
+	}
+	var versions []string
+	for _, cv := range entries {
+		versions = append(versions, cv.Version)
+	}
+	return versions, nil
+}
+
+// ListVersions returns every version of a chart in a repository, the tags of
+// the chart for OCI registries
+func (c *Client) ListVersions(repoURL, name string) ([]string, error) {
+	if IsOCI(repoURL) {
+		return c.ociTags(repoURL, name)
+	}
+	index, err := c.FetchIndex(repoURL)
+	if err != nil {
+		return nil, err
+	}
+	return index.Versions(name)
+}
+
+// ListVersions returns every version of a chart in the cached index
+func (e *Entry) ListVersions(name string) ([]string, error) {
+	if IsOCI(e.URL) {
+		return e.Client.ociTags(e.URL, name)
+	}
+	return e.Index.Versions(name)
+}
+
+// Lists the tags of a chart in an OCI registry as versions
+func (c *Client) ociTags(repoURL, name string) ([]string, error) {
+	// any tag makes a valid reference, only the repository is used
+	ref, err := parseOCIReference(repoURL, name, "latest")
+	if err != nil {
+		return nil, &Error{Reason: ReasonChartNotFound, Err: err}
+	}
+	s := &registrySession{client: c}
+	data, status, err := s.get("https://"+ref.Registry+"/v2/"+ref.Repository+"/tags/list", "")
+	if err != nil {
+		return nil, err
+	}
+	if status == http.StatusNotFound {
+		return nil, &Error{Reason: ReasonChartNotFound, Err: fmt.Errorf("chart %s/%s not found in registry", ref.Registry, ref.Repository)}
+	}
+	if status != http.StatusOK {
+		return nil, &Error{Reason: ReasonFetchFailed, Err: fmt.Errorf("GET tags of %s/%s: %s", ref.Registry, ref.Repository, http.StatusText(status))}
+	}
+	tags := struct {
+		Tags []string `json:"tags"`
+	}{}
+	if err := json.Unmarshal(data, &tags); err != nil {
+		return nil, &Error{Reason: ReasonInvalidManifest, Err: err}
+	}
+	var versions []string
+	for _, tag := range tags.Tags {
+		// helm publishes build metadata with "_" as tags cannot contain "+"
+		versions = append(versions, strings.Replace(tag, "_", "+", -1))
+	}
+	return versions, nil
+}
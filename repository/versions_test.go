@@ -0,0 +1,57 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package repository
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("version ranges", func() {
+	versions := []string{"1.1.0", "1.2.0", "1.2.5", "v1.3.0", "2.0.0", "2.1.0-rc.1", "3.0.0", "nightly"}
+
+	It("should tell ranges from versions", func() {
+		Expect(IsRange("1.2.0")).To(BeFalse())
+		Expect(IsRange("v1.2.0")).To(BeFalse())
+		Expect(IsRange("")).To(BeFalse())
+		Expect(IsRange("~1.2")).To(BeTrue())
+		Expect(IsRange(">=2.0 <3")).To(BeTrue())
+	})
+
+	It("should return the matching versions newest first", func() {
+		Expect(MatchingVersions(versions, "~1.2")).To(Equal([]string{"1.2.5", "1.2.0"}))
+		Expect(MatchingVersions(versions, "^1.1")).To(Equal([]string{"v1.3.0", "1.2.5", "1.2.0", "1.1.0"}))
+		Expect(MatchingVersions(versions, ">=2.0 <3")).To(Equal([]string{"2.0.0"}))
+		Expect(MatchingVersions(versions, "<=3")).To(Equal([]string{"3.0.0", "2.0.0", "v1.3.0", "1.2.5", "1.2.0", "1.1.0"}))
+		Expect(MatchingVersions(versions, ">= 2.0, < 3 || 1.1.0")).To(Equal([]string{"2.0.0", "1.1.0"}))
+		Expect(MatchingVersions(versions, "1.2 - 1.3")).To(Equal([]string{"v1.3.0", "1.2.5", "1.2.0"}))
+		Expect(MatchingVersions(versions, "~4")).To(BeEmpty())
+	})
+
+	It("should reject invalid ranges", func() {
+		_, err := MatchingVersions(versions, ">=latest")
+		Expect(ReasonFor(err)).To(Equal(ReasonInvalidVersion))
+	})
+
+	It("should list the versions of a chart in an index", func() {
+		index := &IndexFile{Entries: map[string][]*ChartVersion{
+			"nginx": {{Name: "nginx", Version: "1.1.0"}, {Name: "nginx", Version: "1.2.0"}},
+		}}
+		Expect(index.Versions("nginx")).To(Equal([]string{"1.1.0", "1.2.0"}))
+		_, err := index.Versions("redis")
+		Expect(ReasonFor(err)).To(Equal(ReasonChartNotFound))
+	})
+})